@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin            = "auth.login"
+	ActionLoginFailed      = "auth.login.failed"
+	ActionLogout           = "auth.logout"
+	ActionAuthzDenied      = "authz.denied"
+	ActionUserCreate       = "user.create"
+	ActionUserUpdate       = "user.update"
+	ActionUserDelete       = "user.delete"
+	ActionUserRestore      = "user.restore"
+	ActionUserPurge        = "user.purge"
+	ActionUserPassword     = "user.password.update"
+	ActionUserSessions     = "user.sessions.revoke"
+	ActionUserUnlock       = "user.unlock"
+	ActionUserTwoFactor    = "user.2fa.reset"
+	ActionUserRoles        = "user.roles.update"
+	ActionUserRoleAdd      = "user.roles.add"
+	ActionRoleCreate       = "role.create"
+	ActionRoleDelete       = "role.delete"
+	ActionRoleTwoFactor    = "role.2fa.update"
+	ActionRoleParentAdd    = "role.parent.add"
+	ActionRoleParentRemove = "role.parent.remove"
+	ActionPolicyCreate     = "policy.create"
+	ActionPolicyDelete     = "policy.delete"
+	ActionPolicyReplace    = "policy.replace"
+)
+
+// Event is an action of the current request on a target
+type Event struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	ActorID    *uint       // Current user if nil
+	Before     interface{} // State before the action, nil for creations
+	After      interface{} // State after the action, nil for deletions
+}
+
+// Record writes an event to the audit log with the actor, IP, user agent and request ID of the
+// request. Only the fields which differ between Before and After are kept. Events are written
+// in background by a single writer, failures are logged and the request is not failed.
+func Record(c *fiber.Ctx, event Event) {
+	// Strings of the request (params, headers) are reused once it is answered, the writer needs copies
+	record := models.AuditEvent{
+		ActorID:    event.ActorID,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   strings.Clone(event.TargetID),
+		IP:         strings.Clone(c.IP()),
+		UserAgent:  strings.Clone(c.Get(fiber.HeaderUserAgent)),
+	}
+	if record.ActorID == nil {
+		if id, err := strconv.ParseUint(fmt.Sprint(c.Locals("userID")), 10, 32); err == nil {
+			actorID := uint(id)
+			record.ActorID = &actorID
+		}
+	}
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		record.RequestID = strings.Clone(requestID)
+	}
+	record.Before, record.After = diff(event.Before, event.After)
+
+	database.QueueAuditEvent(&record)
+}
+
+// diff keeps the changed fields of two JSON objects, other values are kept whole
+func diff(before interface{}, after interface{}) (models.JSON, models.JSON) {
+	b := marshal(before)
+	a := marshal(after)
+
+	var bm, am map[string]interface{}
+	if json.Unmarshal(b, &bm) != nil || json.Unmarshal(a, &am) != nil || bm == nil || am == nil {
+		return b, a
+	}
+
+	for key, value := range bm {
+		if other, ok := am[key]; ok && reflect.DeepEqual(value, other) {
+			delete(bm, key)
+			delete(am, key)
+		}
+	}
+	return marshal(bm), marshal(am)
+}
+
+func marshal(v interface{}) models.JSON {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil || string(raw) == "null" {
+		return nil
+	}
+	return raw
+}
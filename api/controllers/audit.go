@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"gorm.io/gorm"
+)
+
+// AuditQuery filters the audit log
+type AuditQuery struct {
+	PageInput
+	ActorID    string `query:"actorId" valid:"int~actorId must be a user ID"`
+	Action     string `query:"action"`
+	TargetType string `query:"targetType"`
+	TargetID   string `query:"targetId"`
+	RequestID  string `query:"requestId"`
+	From       string `query:"from"` // Inclusive
+	To         string `query:"to"`   // Exclusive
+}
+
+// auditSortColumns maps the sortable fields of audit events to their column
+var auditSortColumns = map[string]string{
+	"id":        "id",
+	"createdAt": "created_at",
+}
+
+// filter restricts a query of audit events to the filters of the input
+func (q AuditQuery) filter(query *gorm.DB) (*gorm.DB, error) {
+	if q.ActorID != "" {
+		query = query.Where("actor_id = ?", q.ActorID)
+	}
+	if q.Action != "" {
+		query = query.Where("action = ?", q.Action)
+	}
+	if q.TargetType != "" {
+		query = query.Where("target_type = ?", q.TargetType)
+	}
+	if q.TargetID != "" {
+		query = query.Where("target_id = ?", q.TargetID)
+	}
+	if q.RequestID != "" {
+		query = query.Where("request_id = ?", q.RequestID)
+	}
+	if q.From != "" {
+		from, err := parseQueryTime("from", q.From)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if q.To != "" {
+		to, err := parseQueryTime("to", q.To)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("created_at < ?", to)
+	}
+	return query, nil
+}
+
+// parseAuditQuery parses and validates the audit log filters of a request
+func parseAuditQuery(c *fiber.Ctx) (AuditQuery, *gorm.DB, error) {
+	var q AuditQuery
+	if err := c.QueryParser(&q); err != nil {
+		return q, nil, utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+	if err := validateInput(&q); err != nil {
+		return q, nil, err
+	}
+
+	query, err := q.filter(database.GetAdminDB().Model(&models.AuditEvent{}))
+	return q, query, err
+}
+
+// GetAuditEvents godoc
+// @Summary     Get audit events
+// @Description Get a page of the audit log, newest first by default. Pages are requested by offset,
+// @Description or by the nextCursor of the previous page
+// @Tags        audit
+// @Accept      json
+// @Produce     json
+// @Param       limit      query    int    false "Page size (default 20, max 100)"
+// @Param       offset     query    int    false "Rows to skip, ignored with cursor"
+// @Param       cursor     query    string false "nextCursor of the previous page"
+// @Param       sort       query    string false "id or createdAt, descending if prefixed by - (default -id)"
+// @Param       actorId    query    int    false "User who did the action"
+// @Param       action     query    string false "Action, Eg. user.update"
+// @Param       targetType query    string false "Target type, Eg. user"
+// @Param       targetId   query    string false "Target ID"
+// @Param       requestId  query    string false "X-Request-ID of the request"
+// @Param       from       query    string false "At or after (2006-01-02 or RFC 3339)"
+// @Param       to         query    string false "Before (2006-01-02 or RFC 3339)"
+// @Success     200        {object} models.Response{data=models.AuditPage}
+// @Failure     400        {object} models.Response
+// @Failure     500        {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/audit/ [get]
+func GetAuditEvents(c *fiber.Ctx) error {
+	q, query, err := parseAuditQuery(c)
+	if err != nil {
+		return err
+	}
+
+	field, column, desc, err := q.sortColumn(auditSortColumns, "-id")
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	paged, err := q.paginate(query, column, desc)
+	if err != nil {
+		return err
+	}
+	events := []models.AuditEvent{}
+	if err := paged.Find(&events).Error; err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	page := models.AuditPage{
+		Data: events,
+		Pagination: models.Pagination{
+			Total:  total,
+			Limit:  q.limit(),
+			Offset: q.Offset,
+		},
+	}
+	if len(events) > q.limit() {
+		page.Data = events[:q.limit()]
+		last := page.Data[len(page.Data)-1]
+		value := strconv.Itoa(int(last.ID))
+		if field == "createdAt" {
+			value = cursorTime(last.CreatedAt)
+		}
+		page.Pagination.NextCursor = encodeCursor(pageCursor{Sort: q.Sort, Value: value, ID: last.ID})
+	}
+	if q.Cursor != "" {
+		page.Pagination.Offset = 0
+	}
+
+	return respond(c, "", page)
+}
+
+// ExportAuditEvents godoc
+// @Summary     Export audit events
+// @Description Stream every audit event matching the filters as newline delimited JSON, oldest first
+// @Tags        audit
+// @Produce     application/x-ndjson
+// @Param       actorId    query    int    false "User who did the action"
+// @Param       action     query    string false "Action, Eg. user.update"
+// @Param       targetType query    string false "Target type, Eg. user"
+// @Param       targetId   query    string false "Target ID"
+// @Param       requestId  query    string false "X-Request-ID of the request"
+// @Param       from       query    string false "At or after (2006-01-02 or RFC 3339)"
+// @Param       to         query    string false "Before (2006-01-02 or RFC 3339)"
+// @Success     200        {string} string "One models.AuditEvent per line"
+// @Failure     400        {object} models.Response
+// @Failure     500        {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/audit/export [get]
+func ExportAuditEvents(c *fiber.Ctx) error {
+	_, query, err := parseAuditQuery(c)
+	if err != nil {
+		return err
+	}
+
+	// Query before streaming, so that errors are still answered with a status
+	rows, err := query.Order("id").Rows()
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.ndjson"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer rows.Close()
+
+		encoder := json.NewEncoder(w)
+		for rows.Next() {
+			var event models.AuditEvent
+			if err := database.GetAdminDB().ScanRows(rows, &event); err != nil {
+				fmt.Printf("failed to export audit events: %v\n", err)
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				// Client went away
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// VerifyAuditEvents godoc
+// @Summary     Verify the audit log
+// @Description Walk the hash chain of the audit log and the signed checkpoints, reporting the first broken link if any
+// @Tags        audit
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=models.AuditVerification}
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/audit/verify [get]
+func VerifyAuditEvents(c *fiber.Ctx) error {
+	report, err := database.VerifyAuditChain()
+	if err != nil {
+		return utils.InternalError("Error when verifying audit log", err)
+	}
+
+	return respond(c, "", report)
+}
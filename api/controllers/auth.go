@@ -1,105 +1,389 @@
 package controllers
 
 import (
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/casbin/casbin/v2"
 	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/audit"
 	"github.com/pcminh0505/gofiber-casbin/api/models"
 	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/config"
 	"github.com/pcminh0505/gofiber-casbin/infras/database"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const refreshCookie = "refresh_token"
+
 type AuthInput struct {
-	Identity string
-	Password string
+	Identity    string `valid:"required~Identity is required"`
+	Password    string `valid:"required~Password is required"`
+	ReturnToken bool   // Return tokens in response body for non-browser clients
+}
+
+type RefreshInput struct {
+	RefreshToken string
 }
 
+// dummyPasswordHash is compared when the identity does not exist, so that failures take the same time
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
 // Login godoc
 // @Summary     Login a user
-// @Description Login with username/email and password, return a cookie
+// @Description Login with username/email and password, return access token and refresh token cookies.
+// @Description Set ReturnToken to also get the tokens in response body to use as Bearer token.
+// @Description Repeated failures lock the account and the client IP for an increasing time.
+// @Description If two-factor authentication is enabled or required, a challenge token is returned instead, see /auth/login/2fa
 // @Tags        auth
 // @Param       data body AuthInput true "Login with Username and Password"
 // @Accept      json
 // @Produce     json
-// @Success     200 {object} models.Response
+// @Success     200 {object} models.Response{data=object}
 // @Failure     400 {object} models.Response
-// @Failure     404 {object} models.Response
+// @Failure     401 {object} models.Response
+// @Failure     403 {object} models.Response
+// @Failure     429 {object} models.Response
 // @Router      /auth/login [post]
 func Login(c *fiber.Ctx) error {
 	// Parse input from request body
 	var data AuthInput
 	if err := c.BodyParser(&data); err != nil {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Invalid request params!",
-		})
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
 	}
 
-	if utils.IsEmpty(data.Identity) || utils.IsEmpty(data.Password) {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Cannot login with empty input!",
-		})
+	if err := validateInput(&data); err != nil {
+		return err
 	}
 
 	var user models.User
-	// If user is not found, return error
-	if res := database.GetAdminDB().Where(
+	found := database.GetAdminDB().Where(
 		&models.User{Email: data.Identity}).Or(
 		&models.User{Username: data.Identity},
-	).First(&user); res.RowsAffected <= 0 {
-		c.Status(fiber.StatusNotFound)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "User not found!",
-		})
+	).First(&user).RowsAffected > 0
+
+	// Unknown identities are counted too, lockout must not tell whether an account exists
+	accountKey := "identity:" + strings.ToLower(data.Identity)
+	if found {
+		accountKey = accountLockKey(user.ID)
+	}
+	ipKey := "ip:" + c.IP()
+
+	if err := checkLoginLockout(c, accountKey, ipKey); err != nil {
+		return err
 	}
 
-	// If password is incorrect, return error
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(data.Password)); err != nil {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Incorrect password!",
+	// Always compare a hash, so that unknown identities fail as slowly as wrong passwords
+	hash := dummyPasswordHash
+	if found {
+		hash = []byte(user.Password)
+	}
+
+	// If user is not found or password is incorrect, return the same error
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(data.Password)); err != nil || !found {
+		database.RecordLoginFailure(accountKey, config.GetEnvInt("LOGIN_MAX_ATTEMPTS", 5))
+		database.RecordLoginFailure(ipKey, config.GetEnvInt("LOGIN_MAX_IP_ATTEMPTS", 20))
+		audit.Record(c, audit.Event{Action: audit.ActionLoginFailed, TargetType: "identity", TargetID: data.Identity})
+
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeInvalidCredentials, "Invalid identity or password!")
+	}
+
+	// Unverified accounts cannot login if required, only told once the password is correct
+	if emailVerificationRequired(user) {
+		return utils.NewError(fiber.StatusForbidden, utils.CodeEmailNotVerified, "Email is not verified, please open the link of the verification email!")
+	}
+
+	// Second factor is required if enabled by the user or by one of their roles
+	tf, err := findTwoFactor(user.ID)
+	required := false
+	if err == nil {
+		required, err = twoFactorRequired(user.ID)
+	}
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	if enabled := tf != nil && tf.EnabledAt != nil; enabled || required {
+		challenge, err := createLoginChallenge(user.ID)
+		if err != nil {
+			return utils.InternalError("Internal Server Error", err)
+		}
+
+		return respond(c, "Two-factor authentication required", fiber.Map{
+			"twoFactorRequired":  true,
+			"enrollmentRequired": !enabled,
+			"challengeToken":     challenge,
+			"expiresIn":          int(loginChallengeTTL.Seconds()),
 		})
 	}
 
+	return completeLogin(c, user, data.ReturnToken, nil)
+}
+
+// accountLockKey returns the login failures key of a user
+func accountLockKey(userID uint) string {
+	return "user:" + strconv.Itoa(int(userID))
+}
+
+// checkLoginLockout fails with 429 if any of the keys is locked out by failed logins
+func checkLoginLockout(c *fiber.Ctx, keys ...string) error {
+	lockout, err := database.LoginLockout(keys...)
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	if lockout > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(lockout.Seconds()))))
+		return utils.NewError(fiber.StatusTooManyRequests, utils.CodeLoginLocked, "Too many failed login attempts, please try again later!")
+	}
+	return nil
+}
+
+// completeLogin issues the tokens of a user who passed every login step.
+// Recovery codes are returned once when 2FA was enrolled during login.
+func completeLogin(c *fiber.Ctx, user models.User, returnToken bool, recoveryCodes []string) error {
+	database.ResetLoginFailures(accountLockKey(user.ID))
+	audit.Record(c, audit.Event{Action: audit.ActionLogin, TargetType: "user", TargetID: strconv.Itoa(int(user.ID)), ActorID: &user.ID})
+
+	accessToken, refreshToken, err := issueTokens(c, user.ID, "")
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	res := fiber.Map{"user": user}
+	if returnToken {
+		res["tokenType"] = "Bearer"
+		res["accessToken"] = accessToken
+		res["refreshToken"] = refreshToken
+		res["expiresIn"] = int(utils.AccessTokenTTL.Seconds())
+	}
+	if len(recoveryCodes) > 0 {
+		res["recoveryCodes"] = recoveryCodes
+	}
+
+	return respond(c, "Login successfully!", res)
+}
+
+// issueTokens creates a JWT access token and a refresh token of the given family (new family if empty)
+// and set them as cookies
+func issueTokens(c *fiber.Ctx, userID uint, familyID string) (string, string, error) {
+	// Embed roles for downstream services if enabled
+	var roles []string
+	if utils.RoleClaimsEnabled() {
+		roles, _ = permanentRoles(database.Casbin(), strconv.Itoa(int(userID)))
+	}
+
 	// Create JWT token with userID.
-	token, err := utils.GenerateJWT(string(c.Request().Host()), user.ID)
+	token, err := utils.GenerateJWT(userID, roles)
+	if err != nil {
+		return "", "", err
+	}
 
+	// Create opaque refresh token, only its hash is stored
+	refreshToken, hash, err := utils.GenerateOpaqueToken()
 	if err != nil {
-		c.Status(fiber.StatusInternalServerError)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Internal Server Error",
-		})
+		return "", "", err
 	}
 
-	// Create cookie
-	cookie := fiber.Cookie{
+	if familyID == "" {
+		familyID, _, err = utils.GenerateOpaqueToken()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	refreshExpires := time.Now().Add(config.GetEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour))
+	if err := database.GetAdminDB().Create(&models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: refreshExpires,
+	}).Error; err != nil {
+		return "", "", err
+	}
+
+	// Create cookies
+	c.Cookie(&fiber.Cookie{
 		Name:     "jwt",
 		Value:    token,
-		Expires:  time.Now().Add(time.Hour * 1),
+		Expires:  time.Now().Add(utils.AccessTokenTTL),
 		HTTPOnly: true,
+	})
+	c.Cookie(&fiber.Cookie{
+		Name:     refreshCookie,
+		Value:    refreshToken,
+		Path:     "/api/auth",
+		Expires:  refreshExpires,
+		HTTPOnly: true,
+	})
+
+	return token, refreshToken, nil
+}
+
+// permanentRoles lists the roles of a user, with the roles they inherit, leaving out the roles
+// assigned with an expiry so that a token never claims a role longer than it is assigned
+func permanentRoles(e *casbin.SyncedEnforcer, userID string) ([]string, error) {
+	expiring := make(map[string]bool)
+	for _, role := range database.ExpiringRoles(userID) {
+		expiring[role] = true
 	}
 
-	c.Cookie(&cookie)
+	direct, err := e.GetRolesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	seen := make(map[string]bool)
+	for _, role := range direct {
+		if expiring[role] {
+			continue
+		}
+		inherited, err := e.GetImplicitRolesForUser(role)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range append([]string{role}, inherited...) {
+			if !seen[name] {
+				seen[name] = true
+				roles = append(roles, name)
+			}
+		}
+	}
+	return roles, nil
+}
+
+// revokeTokenFamily revokes every refresh token issued from the same login
+func revokeTokenFamily(familyID string) error {
+	return database.GetAdminDB().
+		Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserSessions revokes every access token and refresh token of a user
+func revokeUserSessions(userID uint) error {
+	if err := database.GetAdminDB().
+		Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	return database.RevokeUserTokens(userID, utils.AccessTokenTTL)
+}
+
+// Refresh godoc
+// @Summary     Refresh access token
+// @Description Exchange a refresh token for a new access token and a new refresh token. Reusing a refresh token revokes all tokens of the same login
+// @Tags        auth
+// @Param       data body RefreshInput false "Refresh token, read from cookie if empty"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=object}
+// @Failure     401 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Router      /auth/refresh [post]
+func Refresh(c *fiber.Ctx) error {
+	// Refresh token is read from body for non-browser clients, or from cookie
+	var data RefreshInput
+	c.BodyParser(&data)
+	fromBody := !utils.IsEmpty(data.RefreshToken)
+	if !fromBody {
+		data.RefreshToken = c.Cookies(refreshCookie)
+	}
+
+	if utils.IsEmpty(data.RefreshToken) {
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeRefreshTokenMissing, "Missing refresh token!")
+	}
+
+	db := database.GetAdminDB()
+
+	var stored models.RefreshToken
+	if res := db.Where(&models.RefreshToken{TokenHash: utils.HashToken(data.RefreshToken)}).
+		First(&stored); res.RowsAffected <= 0 {
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeRefreshTokenInvalid, "Invalid refresh token!")
+	}
+
+	if stored.RevokedAt != nil || stored.ExpiresAt.Before(time.Now()) {
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeRefreshTokenExpired, "Refresh token expired or revoked!")
+	}
+
+	// Mark as used, only one request can win if the same token is sent concurrently
+	res := db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", stored.ID).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return utils.InternalError("Internal Server Error", res.Error)
+	}
+
+	// Token was already used -> it has been stolen, revoke the whole family
+	if res.RowsAffected == 0 {
+		revokeTokenFamily(stored.FamilyID)
+		c.ClearCookie("jwt", refreshCookie)
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeRefreshTokenReused, "Refresh token reuse detected!")
+	}
+
+	// If user is not found anymore, return error
+	if res := db.First(new(models.User), stored.UserID); res.RowsAffected <= 0 {
+		revokeTokenFamily(stored.FamilyID)
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeUserNotFound, "User not found!")
+	}
+
+	accessToken, refreshToken, err := issueTokens(c, stored.UserID, stored.FamilyID)
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	// Client without cookies needs the rotated tokens in response body
+	if fromBody {
+		return respond(c, "Refresh token successfully!", fiber.Map{
+			"tokenType":    "Bearer",
+			"accessToken":  accessToken,
+			"refreshToken": refreshToken,
+			"expiresIn":    int(utils.AccessTokenTTL.Seconds()),
+		})
+	}
 
-	return c.JSON(user)
+	return respond(c, "Refresh token successfully!", nil)
 }
 
 // Logout godoc
 // @Summary     Logout a user
-// @Description Logout by overriding cookie expired time
+// @Description Logout by revoking the access token and refresh token, and overriding cookie expired time
 // @Tags        auth
+// @Param       data body RefreshInput false "Refresh token, read from cookie if empty"
 // @Accept      json
 // @Produce     json
 // @Success     200 {object} models.Response
 // @Router      /auth/logout [post]
 func Logout(c *fiber.Ctx) error {
+	// Revoke current access token until it expires
+	if claims, err := utils.ParseJWT(utils.ExtractToken(c)); err == nil && claims.ID != "" && claims.ExpiresAt != nil {
+		userID, _ := strconv.ParseUint(claims.Subject, 10, 32)
+		database.RevokeToken(claims.ID, uint(userID), claims.ExpiresAt.Time)
+
+		actorID := uint(userID)
+		audit.Record(c, audit.Event{Action: audit.ActionLogout, TargetType: "user", TargetID: claims.Subject, ActorID: &actorID})
+	}
+
+	// Revoke refresh token family of current login, refresh token is read from body or from cookie like Refresh
+	var data RefreshInput
+	c.BodyParser(&data)
+	if utils.IsEmpty(data.RefreshToken) {
+		data.RefreshToken = c.Cookies(refreshCookie)
+	}
+	if !utils.IsEmpty(data.RefreshToken) {
+		var stored models.RefreshToken
+		if res := database.GetAdminDB().
+			Where(&models.RefreshToken{TokenHash: utils.HashToken(data.RefreshToken)}).
+			First(&stored); res.RowsAffected > 0 {
+			revokeTokenFamily(stored.FamilyID)
+		}
+	}
+
 	// Override expired time of cookie
 	cookie := fiber.Cookie{
 		Name:     "jwt",
@@ -109,9 +393,13 @@ func Logout(c *fiber.Ctx) error {
 	}
 
 	c.Cookie(&cookie)
-
-	return c.JSON(fiber.Map{
-		"error":   false,
-		"message": "Logout successfully!",
+	c.Cookie(&fiber.Cookie{
+		Name:     refreshCookie,
+		Value:    "",
+		Path:     "/api/auth",
+		Expires:  time.Now().Add(-time.Hour),
+		HTTPOnly: true,
 	})
+
+	return respond(c, "Logout successfully!", nil)
 }
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"gorm.io/gorm"
+)
+
+func TestPermanentRolesLeaveOutExpiringRoles(t *testing.T) {
+	_, e, db := newRolesTestApp(t)
+	user := createTestUser(t, e, db, "user")
+	if _, err := e.AddGroupingPolicy("admin", "auditor"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Admin for 8 hours, with the auditor role it inherits
+	expiresAt := time.Now().Add(8 * time.Hour)
+	if err := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+		_, _, err := replaceUserRoles(tx, policy, user.ID, []models.UserRole{{Role: "user"}, {Role: "admin", ExpiresAt: &expiresAt}})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := permanentRoles(e, fmt.Sprint(user.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(roles) != "[user]" {
+		t.Errorf("expected only the permanent roles, got %v", roles)
+	}
+}
+
+func TestLogoutRevokesRefreshTokenFromBody(t *testing.T) {
+	app, e, db := newRolesTestApp(t)
+	app.Post("/api/auth/logout", Logout)
+	if err := db.AutoMigrate(&models.RefreshToken{}); err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, e, db, "user")
+
+	// Two tokens of the same login, the rotated one is sent by a client without cookies
+	for _, token := range []string{"rotated", "current"} {
+		if err := db.Create(&models.RefreshToken{
+			UserID:    user.ID,
+			FamilyID:  "family",
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/auth/logout", strings.NewReader(`{"refreshToken": "current"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	var active int64
+	db.Model(&models.RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", "family").Count(&active)
+	if active != 0 {
+		t.Errorf("expected the refresh token family revoked, %d tokens still active", active)
+	}
+}
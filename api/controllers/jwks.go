@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+)
+
+// JWKS returns the public keys verifying our JWT so other services can validate them.
+// The key set is answered bare, as JWKS clients expect, not in the Response envelope
+func JWKS(c *fiber.Ctx) error {
+	ring, err := utils.CurrentKeyRing()
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(ring.JWKS())
+}
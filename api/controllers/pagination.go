@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// PageInput is the query of a paginated listing.
+// Sort is a column name, descending if prefixed by "-" (Eg. -createdAt).
+type PageInput struct {
+	Limit  int    `query:"limit" valid:"range(0|100)~limit must be between 1 and 100"`
+	Offset int    `query:"offset" valid:"range(0|1000000000)~offset cannot be negative"`
+	Cursor string `query:"cursor"`
+	Sort   string `query:"sort"`
+}
+
+// pageCursor is the position after the last row of a page, encoded in nextCursor
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"i"`
+}
+
+// sortColumn returns the column of the sort field among the sortable ones (json name to column)
+func (p PageInput) sortColumn(columns map[string]string, fallback string) (field string, column string, desc bool, err error) {
+	field = p.Sort
+	if field == "" {
+		field = fallback
+	}
+	if strings.HasPrefix(field, "-") {
+		desc = true
+		field = field[1:]
+	}
+
+	column, ok := columns[field]
+	if !ok {
+		names := make([]string, 0, len(columns))
+		for name := range columns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", "", false, utils.InvalidInput([]utils.FieldError{{
+			Field:   "sort",
+			Rule:    "in",
+			Message: "sort must be one of " + strings.Join(names, ", ") + ", optionally prefixed by -",
+		}})
+	}
+	return field, column, desc, nil
+}
+
+// paginate orders a query by column then id, and restricts it to the page of the input.
+// One more row than the limit is fetched to know if a next page exists.
+func (p PageInput) paginate(query *gorm.DB, column string, desc bool) (*gorm.DB, error) {
+	order, cmp := " ASC", ">"
+	if desc {
+		order, cmp = " DESC", "<"
+	}
+	query = query.Order(column + order).Order("id" + order).Limit(p.limit() + 1)
+
+	if p.Cursor == "" {
+		return query.Offset(p.Offset), nil
+	}
+
+	cursor, err := decodeCursor(p.Cursor)
+	if err != nil || cursor.Sort != p.Sort {
+		return nil, utils.InvalidInput([]utils.FieldError{{
+			Field:   "cursor",
+			Rule:    "cursor",
+			Message: "cursor is invalid or was issued for another sort",
+		}})
+	}
+	if column == "id" {
+		return query.Where("id "+cmp+" ?", cursor.ID), nil
+	}
+	return query.Where("("+column+" "+cmp+" ?) OR ("+column+" = ? AND id "+cmp+" ?)",
+		cursor.Value, cursor.Value, cursor.ID), nil
+}
+
+// limit returns the page size, defaultPageLimit if unset
+func (p PageInput) limit() int {
+	if p.Limit <= 0 {
+		return defaultPageLimit
+	}
+	if p.Limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return p.Limit
+}
+
+func encodeCursor(cursor pageCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var cursor pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(raw, &cursor)
+	return cursor, err
+}
+
+// cursorTime formats a time sort value of a cursor
+func cursorTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseQueryTime parses a date (2006-01-02) or RFC 3339 time of a query field
+func parseQueryTime(field string, value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, utils.InvalidInput([]utils.FieldError{{
+		Field:   field,
+		Rule:    "time",
+		Message: field + " must be a date (2006-01-02) or RFC 3339 time",
+	}})
+}
+
+// likePattern matches values containing s, wildcards of s are escaped
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
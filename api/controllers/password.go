@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// validatePassword checks a new password of a user against the password policy,
+// and against the current and previous passwords if the user already exists
+func validatePassword(password string, user models.User) ([]utils.PasswordViolation, error) {
+	policy := utils.PasswordPolicyFromEnv()
+	violations := policy.Validate(password, user.Username, user.Email)
+	if len(violations) > 0 || user.ID == 0 || policy.HistorySize <= 0 {
+		return violations, nil
+	}
+
+	var history []models.PasswordHistory
+	if err := database.GetAdminDB().
+		Where(&models.PasswordHistory{UserID: user.ID}).
+		Order("id desc").
+		Limit(policy.HistorySize).
+		Find(&history).Error; err != nil {
+		return nil, err
+	}
+
+	// Current password may predate the history
+	hashes := []string{user.Password}
+	for _, h := range history {
+		hashes = append(hashes, h.Password)
+	}
+
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			violations = append(violations, utils.PasswordViolation{
+				Code:    "reused",
+				Message: fmt.Sprintf("Password must differ from the last %d passwords", policy.HistorySize),
+			})
+			break
+		}
+	}
+	return violations, nil
+}
+
+// savePasswordHistory records the hash of a password just set, keeping only the history size
+func savePasswordHistory(tx *gorm.DB, userID uint, hash string) error {
+	if err := tx.Create(&models.PasswordHistory{UserID: userID, Password: hash}).Error; err != nil {
+		return err
+	}
+
+	keep := tx.Model(&models.PasswordHistory{}).
+		Select("id").
+		Where(&models.PasswordHistory{UserID: userID}).
+		Order("id desc").
+		Limit(utils.PasswordPolicyFromEnv().HistorySize)
+	return tx.Where("user_id = ? AND id NOT IN (?)", userID, keep).Delete(&models.PasswordHistory{}).Error
+}
+
+// passwordViolations reports the rules broken by the password of an input field, like invalid fields
+func passwordViolations(field string, violations []utils.PasswordViolation) error {
+	fields := make([]utils.FieldError, 0, len(violations))
+	for _, v := range violations {
+		fields = append(fields, utils.FieldError{Field: field, Rule: v.Code, Message: v.Message})
+	}
+
+	return utils.NewError(fiber.StatusBadRequest, utils.CodePasswordPolicy, "Password does not meet the password policy!").
+		WithFields(fields)
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/util"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/audit"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"gorm.io/gorm"
+)
+
+// Policy errors returned from within a CasbinTransaction
+var (
+	errPolicyExists   = errors.New("policy exists")
+	errPolicyNotFound = errors.New("policy not found")
+	errPolicyLockout  = errors.New("policy lockout")
+)
+
+type PolicyInput struct {
+	Subject string
+	Object  string
+	Action  string
+}
+
+// validatePolicy checks if a policy can be enforced by the RESTful RBAC model
+func validatePolicy(data PolicyInput) error {
+	if utils.IsEmpty(data.Subject) || utils.IsEmpty(data.Object) || utils.IsEmpty(data.Action) {
+		return errors.New("Subject, object and action are required!")
+	}
+
+	if !utils.IsValidPolicyObject(data.Object) {
+		return errors.New("Invalid object pattern: " + data.Object)
+	}
+
+	if !utils.IsValidPolicyAction(data.Action) {
+		return errors.New("Invalid action pattern: " + data.Action)
+	}
+
+	return nil
+}
+
+// toPolicies converts Casbin rules into Policy models
+func toPolicies(rules [][]string) []models.Policy {
+	policies := make([]models.Policy, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule) < 3 {
+			continue
+		}
+		policies = append(policies, models.Policy{
+			Subject: rule[0],
+			Object:  rule[1],
+			Action:  rule[2],
+		})
+	}
+	return policies
+}
+
+// keepsAccess checks if the current user would still be allowed the current request by the given rules
+func keepsAccess(e *casbin.SyncedEnforcer, c *fiber.Ctx, rules [][]string) (bool, error) {
+	userID, _ := c.Locals("userID").(string)
+	roles, err := database.ActiveImplicitRoles(e, userID)
+	if err != nil {
+		return false, err
+	}
+	subjects := append(roles, userID)
+
+	for _, rule := range rules {
+		if !contains(subjects, rule[0]) {
+			continue
+		}
+		obj := c.OriginalURL()
+		if (util.KeyMatch(obj, rule[1]) || util.KeyMatch2(obj, rule[1])) && util.RegexMatch(c.Method(), rule[2]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func equalRules(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GetPolicies godoc
+// @Summary     Get all policies
+// @Description Get all policies, optionally filtered by subject, object and action
+// @Tags        policies
+// @Accept      json
+// @Produce     json
+// @Param       sub query    string false "Filter by subject"
+// @Param       obj query    string false "Filter by object"
+// @Param       act query    string false "Filter by action"
+// @Success     200 {object} models.Response{data=[]models.Policy}
+// @Failure     401 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/policies/ [get]
+func GetPolicies(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rules := e.GetFilteredPolicy(0, c.Query("sub"), c.Query("obj"), c.Query("act"))
+
+		return respond(c, "", toPolicies(rules))
+	}
+}
+
+// CreatePolicy godoc
+// @Summary     Add new policy
+// @Description Add new policy with subject, object and action
+// @Tags        policies
+// @Param       data body PolicyInput true "Enter policy's info"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=models.Policy}
+// @Failure     400 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/policies/ [post]
+func CreatePolicy(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse input from request body
+		var data PolicyInput
+		if err := c.BodyParser(&data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+		}
+
+		if err := validatePolicy(data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidPolicy, err.Error())
+		}
+
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			if policy.HasPolicy(data.Subject, data.Object, data.Action) {
+				return errPolicyExists
+			}
+			return policy.AddPolicy(data.Subject, data.Object, data.Action)
+		})
+
+		if errors.Is(res, errPolicyExists) {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodePolicyExists, "Policy is already existed")
+		} else if res != nil {
+			return utils.InternalError("Error when adding policy", res)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionPolicyCreate, TargetType: "policy", TargetID: data.Subject, After: models.Policy(data)})
+
+		return respond(c, "Add policy successfully!", models.Policy(data))
+	}
+}
+
+// DeletePolicy godoc
+// @Summary     Remove policy
+// @Description Remove policy matching subject, object and action. The removal must keep the caller's access
+// @Description to this endpoint
+// @Tags        policies
+// @Param       data body PolicyInput true "Enter policy's info"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response
+// @Failure     400 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/policies/ [delete]
+func DeletePolicy(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse input from request body
+		var data PolicyInput
+		if err := c.BodyParser(&data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+		}
+
+		if err := validatePolicy(data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidPolicy, err.Error())
+		}
+
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			rule := []string{data.Subject, data.Object, data.Action}
+			if !policy.HasPolicy(rule...) {
+				return errPolicyNotFound
+			}
+
+			// Nobody could undo a removal which locks the caller out of this endpoint
+			var remaining [][]string
+			for _, r := range policy.GetFilteredPolicy(0) {
+				if !equalRules(r, rule) {
+					remaining = append(remaining, r)
+				}
+			}
+			allowed, err := keepsAccess(e, c, remaining)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				return errPolicyLockout
+			}
+
+			return policy.RemovePolicy(rule...)
+		})
+
+		if errors.Is(res, errPolicyNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodePolicyNotFound, "Policy not found")
+		} else if errors.Is(res, errPolicyLockout) {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodePolicyLockout, "Policies must keep your access to "+c.Method()+" "+c.OriginalURL())
+		} else if res != nil {
+			return utils.InternalError("Error when removing policy", res)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionPolicyDelete, TargetType: "policy", TargetID: data.Subject, Before: models.Policy(data)})
+
+		return respond(c, "Remove policy successfully!", nil)
+	}
+}
+
+// ReplacePolicies godoc
+// @Summary     Replace all policies
+// @Description Replace every policy with the given list, atomically. The list must not be empty and must keep
+// @Description the caller's access to this endpoint
+// @Tags        policies
+// @Param       data body []PolicyInput true "Enter list of policies"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=[]models.Policy}
+// @Failure     400 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/policies/ [put]
+func ReplacePolicies(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse input from request body
+		var data []PolicyInput
+		if err := c.BodyParser(&data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+		}
+
+		// Validate and deduplicate every policy before touching the enforcer
+		seen := make(map[PolicyInput]bool)
+		var rules [][]string
+		for _, p := range data {
+			if err := validatePolicy(p); err != nil {
+				return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidPolicy, err.Error())
+			}
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			rules = append(rules, []string{p.Subject, p.Object, p.Action})
+		}
+
+		if len(rules) == 0 {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidPolicy, "At least one policy is required!")
+		}
+
+		// Nobody could undo a replacement which locks the caller out of this endpoint
+		allowed, err := keepsAccess(e, c, rules)
+		if err != nil {
+			return utils.InternalError("Error when reading roles of current user", err)
+		}
+		if !allowed {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodePolicyLockout, "Policies must keep your access to "+c.Method()+" "+c.OriginalURL())
+		}
+
+		var oldRules [][]string
+		if err := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			oldRules = policy.GetFilteredPolicy(0)
+			if err := policy.RemovePolicies(oldRules); err != nil {
+				return err
+			}
+			return policy.AddPolicies(rules)
+		}); err != nil {
+			return utils.InternalError("Error when replacing policies", err)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionPolicyReplace, TargetType: "policy", Before: toPolicies(oldRules), After: toPolicies(rules)})
+
+		return respond(c, "Replace policies successfully!", toPolicies(rules))
+	}
+}
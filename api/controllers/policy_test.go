@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// policyRequest sends a request to /api/admin/policies/
+func policyRequest(t *testing.T, app *fiber.App, method string, body string) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/api/admin/policies/", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var data struct{ Code string }
+	json.NewDecoder(res.Body).Decode(&data)
+	return res.StatusCode, data.Code
+}
+
+func TestCreateAndDeletePolicy(t *testing.T) {
+	app, e, db := newRolesTestApp(t)
+	user := createTestUser(t, e, db, "admin")
+	if _, err := e.AddPolicy("admin", "/api/admin/*", "(GET)|(POST)|(PUT)|(DELETE)"); err != nil {
+		t.Fatal(err)
+	}
+
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("userID", fmt.Sprint(user.ID))
+		return c.Next()
+	})
+	app.Post("/api/admin/policies/", CreatePolicy(e))
+	app.Delete("/api/admin/policies/", DeletePolicy(e))
+
+	report := `{"subject": "user", "object": "/api/reports/*", "action": "GET"}`
+	if status, code := policyRequest(t, app, fiber.MethodPost, report); status != fiber.StatusOK {
+		t.Fatalf("expected 200 on create, got %d (%s)", status, code)
+	}
+	if status, code := policyRequest(t, app, fiber.MethodPost, report); status != fiber.StatusBadRequest || code != "policy_exists" {
+		t.Errorf("expected 400 policy_exists, got %d (%s)", status, code)
+	}
+	if !e.HasPolicy("user", "/api/reports/*", "GET") {
+		t.Error("created policy is not enforced")
+	}
+
+	if status, code := policyRequest(t, app, fiber.MethodDelete, `{"subject": "user"}`); status != fiber.StatusBadRequest || code != "invalid_policy" {
+		t.Errorf("expected 400 invalid_policy for an incomplete policy, got %d (%s)", status, code)
+	}
+
+	// Removing the only rule granting the caller access is rejected
+	admin := `{"subject": "admin", "object": "/api/admin/*", "action": "(GET)|(POST)|(PUT)|(DELETE)"}`
+	if status, code := policyRequest(t, app, fiber.MethodDelete, admin); status != fiber.StatusBadRequest || code != "policy_lockout" {
+		t.Errorf("expected 400 policy_lockout, got %d (%s)", status, code)
+	}
+	if !e.HasPolicy("admin", "/api/admin/*", "(GET)|(POST)|(PUT)|(DELETE)") {
+		t.Error("admin policy removed")
+	}
+
+	if status, code := policyRequest(t, app, fiber.MethodDelete, report); status != fiber.StatusOK {
+		t.Errorf("expected 200 on delete, got %d (%s)", status, code)
+	}
+	if status, code := policyRequest(t, app, fiber.MethodDelete, report); status != fiber.StatusNotFound || code != "policy_not_found" {
+		t.Errorf("expected 404 policy_not_found, got %d (%s)", status, code)
+	}
+}
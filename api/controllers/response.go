@@ -0,0 +1,11 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+)
+
+// respond answers a success in the Response envelope, data is left out if nil
+func respond(c *fiber.Ctx, message string, data interface{}) error {
+	return c.JSON(models.Response{Message: message, Data: data})
+}
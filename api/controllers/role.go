@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/audit"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"gorm.io/gorm"
+)
+
+// Role inheritance errors returned from within a CasbinTransaction
+var (
+	errRoleInheritanceCycle    = errors.New("role inheritance cycle")
+	errRoleInheritanceExists   = errors.New("role inheritance exists")
+	errRoleInheritanceNotFound = errors.New("role inheritance not found")
+)
+
+type RoleInput struct {
+	Name        string
+	Description string
+}
+
+type RoleParentInput struct {
+	Parent string
+}
+
+func init() {
+	// `valid:"role"` checks that a role is defined
+	utils.RegisterValidator("role", roleExists)
+}
+
+// roleExists checks if a role is defined in AdminDB
+func roleExists(name string) bool {
+	if utils.IsEmpty(name) {
+		return false
+	}
+
+	count := database.GetAdminDB().
+		Where(&models.Role{Name: name}).
+		First(new(models.Role)).
+		RowsAffected
+	return count > 0
+}
+
+// GetRoles godoc
+// @Summary  Get all roles
+// @Tags     roles
+// @Accept   json
+// @Produce  json
+// @Success  200 {object} models.Response{data=[]models.Role}
+// @Failure  401 {object} models.Response
+// @Failure  500 {object} models.Response
+// @Security BearerAuth
+// @Router   /admin/roles/ [get]
+func GetRoles(c *fiber.Ctx) error {
+	var roles []models.Role
+	if err := database.GetAdminDB().Find(&roles).Error; err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	return respond(c, "", roles)
+}
+
+// GetRole godoc
+// @Summary     Get a role by name
+// @Description Get a role and the roles it directly inherits from
+// @Tags        roles
+// @Accept      json
+// @Produce     json
+// @Param       name path     string true "Role name"
+// @Success     200  {object} models.Response{data=object}
+// @Failure     404  {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/roles/{name} [get]
+func GetRole(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		var role models.Role
+		err := database.GetAdminDB().Where(&models.Role{Name: name}).First(&role).Error
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+		} else if err != nil {
+			return utils.InternalError("Internal Server Error", err)
+		}
+
+		parents, _ := e.GetRolesForUser(name)
+
+		return respond(c, "", fiber.Map{
+			"role":    role,
+			"parents": parents,
+		})
+	}
+}
+
+// CreateRole godoc
+// @Summary     Create new role
+// @Description Create new role with name and description
+// @Tags        roles
+// @Param       data body RoleInput true "Enter role's info"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=models.Role}
+// @Failure     400 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/roles/ [post]
+func CreateRole(c *fiber.Ctx) error {
+	// Parse input from request body
+	var data RoleInput
+	if err := c.BodyParser(&data); err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+
+	if !utils.IsValidRoleName(data.Name) {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidRoleName, "Invalid role name: "+data.Name)
+	}
+
+	// If existed role is found, return error
+	if roleExists(data.Name) {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeRoleExists, "Role is already existed")
+	}
+
+	role := models.Role{
+		Name:        data.Name,
+		Description: data.Description,
+	}
+
+	if err := database.GetAdminDB().Create(&role).Error; err != nil {
+		return utils.InternalError("Error when creating role: "+data.Name, err)
+	}
+
+	audit.Record(c, audit.Event{Action: audit.ActionRoleCreate, TargetType: "role", TargetID: role.Name, After: role})
+
+	return respond(c, "Create role successfully!", role)
+}
+
+// DeleteRole godoc
+// @Summary     Delete role
+// @Description Delete role with its policies and inheritances. Role must not be assigned to any user
+// @Tags        roles
+// @Accept      json
+// @Produce     json
+// @Param       name path     string true "Role name"
+// @Success     200  {object} models.Response
+// @Failure     400  {object} models.Response
+// @Failure     404  {object} models.Response
+// @Failure     500  {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/roles/{name} [delete]
+func DeleteRole(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+
+		if !roleExists(name) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+		}
+
+		db := database.GetAdminDB()
+
+		// If role is still assigned to a user, return error. Expired assignments and the ones of deleted users are dropped
+		if count := db.
+			Joins("JOIN users ON users.id = user_roles.user_id AND users.deleted_at IS NULL").
+			Where("user_roles.expires_at IS NULL OR user_roles.expires_at > ?", time.Now()).
+			Where(&models.UserRole{Role: name}).
+			First(new(models.UserRole)).
+			RowsAffected; count > 0 {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeRoleInUse, "Role is still assigned to users")
+		}
+
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			if err := tx.Where(&models.Role{Name: name}).Delete(&models.Role{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where(&models.UserRole{Role: name}).Delete(&models.UserRole{}).Error; err != nil {
+				return err
+			}
+
+			// Remove policies and inheritances where role is the child
+			if err := policy.RemoveFilteredPolicy(0, name); err != nil {
+				return err
+			}
+			if err := policy.RemoveFilteredGroupingPolicy(0, name); err != nil {
+				return err
+			}
+
+			// Remove inheritances where role is the parent, and its remaining user assignments
+			return policy.RemoveFilteredGroupingPolicy(1, name)
+		})
+
+		if res != nil {
+			return utils.InternalError("Error when deleting role: "+name, res)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionRoleDelete, TargetType: "role", TargetID: name})
+
+		return respond(c, "Delete role successfully!", nil)
+	}
+}
+
+// AddRoleParent godoc
+// @Summary     Add role inheritance
+// @Description Make a role inherit all permissions of a parent role
+// @Tags        roles
+// @Param       name path string          true "Role name"
+// @Param       data body RoleParentInput true "Enter parent role"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response
+// @Failure     400 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/roles/{name}/parents [post]
+func AddRoleParent(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Copied since it is kept in the policy, params are only valid during the request
+		name := strings.Clone(c.Params("name"))
+
+		// Parse input from request body
+		var data RoleParentInput
+		if err := c.BodyParser(&data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+		}
+
+		if !roleExists(name) || !roleExists(data.Parent) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+		}
+
+		// Checked within the transaction, so that concurrent requests cannot build a loop together
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			// If parent already inherits from role, the hierarchy would loop
+			if data.Parent == name || contains(policy.GetImplicitRoles(data.Parent), name) {
+				return errRoleInheritanceCycle
+			}
+			if policy.HasGroupingPolicy(name, data.Parent) {
+				return errRoleInheritanceExists
+			}
+			return policy.AddGroupingPolicy(name, data.Parent)
+		})
+
+		if errors.Is(res, errRoleInheritanceCycle) {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeRoleInheritanceCycle, "Role inheritance cannot be circular")
+		} else if errors.Is(res, errRoleInheritanceExists) {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeRoleInheritanceExists, "Role inheritance is already existed")
+		} else if res != nil {
+			return utils.InternalError("Error when adding role inheritance", res)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionRoleParentAdd, TargetType: "role", TargetID: name, After: data})
+
+		return respond(c, "Add role inheritance successfully!", nil)
+	}
+}
+
+// RemoveRoleParent godoc
+// @Summary  Remove role inheritance
+// @Tags     roles
+// @Accept   json
+// @Produce  json
+// @Param    name   path     string true "Role name"
+// @Param    parent path     string true "Parent role name"
+// @Success  200    {object} models.Response
+// @Failure  404    {object} models.Response
+// @Failure  500    {object} models.Response
+// @Security BearerAuth
+// @Router   /admin/roles/{name}/parents/{parent} [delete]
+func RemoveRoleParent(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		parent := c.Params("parent")
+
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			if !policy.HasGroupingPolicy(name, parent) {
+				return errRoleInheritanceNotFound
+			}
+			return policy.RemoveGroupingPolicy(name, parent)
+		})
+
+		if errors.Is(res, errRoleInheritanceNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeRoleInheritanceNotFound, "Role inheritance not found")
+		} else if res != nil {
+			return utils.InternalError("Error when removing role inheritance", res)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionRoleParentRemove, TargetType: "role", TargetID: name, Before: RoleParentInput{Parent: parent}})
+
+		return respond(c, "Remove role inheritance successfully!", nil)
+	}
+}
+
+// GetRolePermissions godoc
+// @Summary     Get effective permissions of a role
+// @Description Get policies of a role including the ones inherited from parent roles
+// @Tags        roles
+// @Accept      json
+// @Produce     json
+// @Param       name path     string true "Role name"
+// @Success     200  {object} models.Response{data=[]models.Policy}
+// @Failure     404  {object} models.Response
+// @Failure     500  {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/roles/{name}/permissions [get]
+func GetRolePermissions(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Params("name")
+
+		if !roleExists(name) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+		}
+
+		rules, err := e.GetImplicitPermissionsForUser(name)
+		if err != nil {
+			return utils.InternalError("Error when reading role permissions", err)
+		}
+
+		return respond(c, "", toPolicies(rules))
+	}
+}
+
+// contains checks if a string slice has an element
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
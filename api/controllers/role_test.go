@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAddRoleParentRejectsConcurrentCycle(t *testing.T) {
+	app, e, _ := newRolesTestApp(t)
+	app.Post("/api/admin/roles/:name/parents", AddRoleParent(e))
+	app.Delete("/api/admin/roles/:name/parents/:parent", RemoveRoleParent(e))
+
+	// admin -> oncall and oncall -> admin at the same time, only one may pass
+	statuses := make(chan int, 2)
+	var wg sync.WaitGroup
+	for _, pair := range [][2]string{{"admin", "oncall"}, {"oncall", "admin"}} {
+		wg.Add(1)
+		go func(name string, parent string) {
+			defer wg.Done()
+			req := httptest.NewRequest(fiber.MethodPost, "/api/admin/roles/"+name+"/parents", strings.NewReader(`{"parent": "`+parent+`"}`))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			res, err := app.Test(req, -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses <- res.StatusCode
+		}(pair[0], pair[1])
+	}
+	wg.Wait()
+	close(statuses)
+
+	accepted := 0
+	for status := range statuses {
+		if status == fiber.StatusOK {
+			accepted++
+		} else if status != fiber.StatusBadRequest {
+			t.Errorf("unexpected status %d", status)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("expected exactly one inheritance added, got %d", accepted)
+	}
+	if e.HasGroupingPolicy("admin", "oncall") && e.HasGroupingPolicy("oncall", "admin") {
+		t.Fatal("role inheritance loops")
+	}
+
+	// Removing an inheritance which does not exist
+	res, err := app.Test(httptest.NewRequest(fiber.MethodDelete, "/api/admin/roles/user/parents/admin", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusNotFound {
+		t.Errorf("expected 404, got %d", res.StatusCode)
+	}
+}
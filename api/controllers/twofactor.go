@@ -0,0 +1,517 @@
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/audit"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/config"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"gorm.io/gorm"
+)
+
+const (
+	loginChallengeTTL    = 5 * time.Minute
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+type TwoFactorCodeInput struct {
+	Code string `valid:"required~Code is required"`
+}
+
+type LoginTwoFactorInput struct {
+	ChallengeToken string `valid:"required~Challenge token is required"`
+	Code           string `valid:"required~Code is required"` // TOTP code or recovery code
+	ReturnToken    bool   // Return tokens in response body for non-browser clients
+}
+
+type LoginChallengeInput struct {
+	ChallengeToken string `valid:"required~Challenge token is required"`
+}
+
+type RoleTwoFactorInput struct {
+	Required bool
+}
+
+// totpIssuer returns the issuer shown by authenticator apps
+func totpIssuer() string {
+	if issuer := config.GetEnv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "gofiber-casbin"
+}
+
+// isSelf checks if the user ID of the path is the authenticated user
+func isSelf(c *fiber.Ctx, id string) bool {
+	userID, _ := c.Locals("userID").(string)
+	return userID != "" && userID == id
+}
+
+// twoFactorRequired checks if a role of the user, direct or inherited, requires a second factor
+func twoFactorRequired(userID uint) (bool, error) {
+	roles, err := database.ActiveImplicitRoles(database.Casbin(), strconv.Itoa(int(userID)))
+	if err != nil || len(roles) == 0 {
+		return false, err
+	}
+
+	var count int64
+	err = database.GetAdminDB().
+		Model(&models.Role{}).
+		Where("name IN ? AND require_two_factor", roles).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// findTwoFactor returns the TOTP secret of a user, enabled or pending, nil if none
+func findTwoFactor(userID uint) (*models.TwoFactor, error) {
+	var tf models.TwoFactor
+	res := database.GetAdminDB().Where(&models.TwoFactor{UserID: userID}).Limit(1).Find(&tf)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+	return &tf, nil
+}
+
+// beginTwoFactor creates a pending TOTP secret for a user, replacing a previous pending one
+func beginTwoFactor(user models.User) (fiber.Map, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.GetAdminDB().
+		Where(&models.TwoFactor{UserID: user.ID}).
+		Assign(map[string]interface{}{"secret": secret, "last_step": 0, "enabled_at": nil}).
+		FirstOrCreate(new(models.TwoFactor)).Error; err != nil {
+		return nil, err
+	}
+
+	account := user.Username
+	if account == "" {
+		account = user.Email
+	}
+
+	return fiber.Map{
+		"secret":     secret,
+		"otpauthUri": utils.TOTPURI(totpIssuer(), account, secret),
+	}, nil
+}
+
+// useTOTP validates a TOTP code and consumes its time step, so that it cannot be replayed
+func useTOTP(tf *models.TwoFactor, code string) (bool, error) {
+	step, ok := utils.ValidateTOTP(tf.Secret, code, time.Now(), tf.LastStep)
+	if !ok {
+		return false, nil
+	}
+
+	res := database.GetAdminDB().
+		Model(&models.TwoFactor{}).
+		Where("id = ? AND last_step < ?", tf.ID, step).
+		Update("last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// useRecoveryCode consumes a recovery code of a user
+func useRecoveryCode(userID uint, code string) (bool, error) {
+	res := database.GetAdminDB().
+		Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return res.RowsAffected > 0, res.Error
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code, of a user with 2FA enabled
+func verifySecondFactor(tf *models.TwoFactor, code string) (bool, error) {
+	if ok, err := useTOTP(tf, code); ok || err != nil {
+		return ok, err
+	}
+	return useRecoveryCode(tf.UserID, code)
+}
+
+// replaceRecoveryCodes invalidates the recovery codes of a user and returns new ones
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, hashes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where(&models.RecoveryCode{UserID: userID}).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]models.RecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		recoveryCodes = append(recoveryCodes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	if err := tx.Create(&recoveryCodes).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// enableTwoFactor confirms a pending enrollment and returns the recovery codes of the user
+func enableTwoFactor(tf *models.TwoFactor) ([]string, error) {
+	var codes []string
+	err := database.GetAdminDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(tf).Update("enabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, tf.UserID)
+		return err
+	})
+	return codes, err
+}
+
+// createLoginChallenge starts the second login step of a user, only the hash of the token is stored
+func createLoginChallenge(userID uint) (string, error) {
+	token, hash, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	db := database.GetAdminDB()
+	db.Where("expires_at < ?", time.Now()).Delete(&models.LoginChallenge{})
+
+	if err := db.Create(&models.LoginChallenge{
+		UserID:    userID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// findLoginChallenge returns a pending login challenge, nil if unknown, expired or attempted too many times
+func findLoginChallenge(token string) *models.LoginChallenge {
+	var challenge models.LoginChallenge
+	if res := database.GetAdminDB().
+		Where(&models.LoginChallenge{TokenHash: utils.HashToken(token)}).
+		First(&challenge); res.RowsAffected <= 0 {
+		return nil
+	}
+
+	if challenge.ExpiresAt.Before(time.Now()) || challenge.Attempts >= maxChallengeAttempts {
+		return nil
+	}
+	return &challenge
+}
+
+// LoginTwoFactor godoc
+// @Summary     Complete login with a second factor
+// @Description Send the challenge token returned by login with a TOTP code or a recovery code.
+// @Description If the enrollment was required, the code confirms it and recovery codes are returned
+// @Tags        auth
+// @Param       data body LoginTwoFactorInput true "Challenge token and code"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=object}
+// @Failure     400 {object} models.Response
+// @Failure     401 {object} models.Response
+// @Failure     429 {object} models.Response
+// @Router      /auth/login/2fa [post]
+func LoginTwoFactor(c *fiber.Ctx) error {
+	// Parse input from request body
+	var data LoginTwoFactorInput
+	if err := c.BodyParser(&data); err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+
+	if err := validateInput(&data); err != nil {
+		return err
+	}
+
+	challenge := findLoginChallenge(data.ChallengeToken)
+	if challenge == nil {
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeLoginChallengeInvalid, "Invalid or expired login challenge!")
+	}
+
+	// Codes are guessed like passwords, they share the lockout
+	accountKey := accountLockKey(challenge.UserID)
+	ipKey := "ip:" + c.IP()
+	if err := checkLoginLockout(c, accountKey, ipKey); err != nil {
+		return err
+	}
+
+	var user models.User
+	db := database.GetAdminDB()
+	if err := db.First(&user, challenge.UserID).Error; err != nil {
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeLoginChallengeInvalid, "Invalid or expired login challenge!")
+	}
+
+	tf, err := findTwoFactor(user.ID)
+	if err == nil && tf == nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeTwoFactorEnrollment, "Two-factor enrollment required, start it with /auth/2fa/enroll")
+	}
+
+	// Enabled: TOTP or recovery code. Pending: first TOTP code confirms the enrollment
+	var ok bool
+	var recoveryCodes []string
+	if err == nil && tf.EnabledAt != nil {
+		ok, err = verifySecondFactor(tf, data.Code)
+	} else if err == nil {
+		if ok, err = useTOTP(tf, data.Code); ok && err == nil {
+			recoveryCodes, err = enableTwoFactor(tf)
+		}
+	}
+
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	if !ok {
+		db.Model(challenge).Update("attempts", gorm.Expr("attempts + 1"))
+		database.RecordLoginFailure(accountKey, config.GetEnvInt("LOGIN_MAX_ATTEMPTS", 5))
+		database.RecordLoginFailure(ipKey, config.GetEnvInt("LOGIN_MAX_IP_ATTEMPTS", 20))
+
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeTwoFactorCodeInvalid, "Invalid two-factor code!")
+	}
+
+	db.Delete(challenge)
+	return completeLogin(c, user, data.ReturnToken, recoveryCodes)
+}
+
+// EnrollTwoFactorChallenge godoc
+// @Summary     Enroll two-factor authentication during login
+// @Description Start the enrollment required by the role of a user who has not enabled 2FA yet,
+// @Description then complete the login with a code from the authenticator app
+// @Tags        auth
+// @Param       data body LoginChallengeInput true "Challenge token returned by login"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=object}
+// @Failure     400 {object} models.Response
+// @Failure     401 {object} models.Response
+// @Router      /auth/2fa/enroll [post]
+func EnrollTwoFactorChallenge(c *fiber.Ctx) error {
+	// Parse input from request body
+	var data LoginChallengeInput
+	if err := c.BodyParser(&data); err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+
+	challenge := findLoginChallenge(data.ChallengeToken)
+	var user models.User
+	if challenge == nil || database.GetAdminDB().First(&user, challenge.UserID).Error != nil {
+		return utils.NewError(fiber.StatusUnauthorized, utils.CodeLoginChallengeInvalid, "Invalid or expired login challenge!")
+	}
+
+	return startEnrollment(c, user)
+}
+
+// startEnrollment answers the pending secret of a user who has not enabled 2FA yet
+func startEnrollment(c *fiber.Ctx, user models.User) error {
+	tf, err := findTwoFactor(user.ID)
+	if err == nil && tf != nil && tf.EnabledAt != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeTwoFactorEnabled, "Two-factor authentication is already enabled")
+	}
+
+	var res fiber.Map
+	if err == nil {
+		res, err = beginTwoFactor(user)
+	}
+	if err != nil {
+		return utils.InternalError("Error when starting two-factor enrollment", err)
+	}
+
+	return respond(c, "Scan the otpauth URI with an authenticator app, then confirm with a code", res)
+}
+
+// BeginTwoFactor godoc
+// @Summary     Start two-factor enrollment
+// @Description Create a TOTP secret for the current user, returned with an otpauth URI for authenticator apps
+// @Tags        two-factor
+// @Accept      json
+// @Produce     json
+// @Param       id  path     int true "User ID"
+// @Success     200 {object} models.Response{data=object}
+// @Failure     400 {object} models.Response
+// @Failure     403 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /users/{id}/2fa [put]
+func BeginTwoFactor(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if !isSelf(c, id) || database.GetAdminDB().First(&user, id).Error != nil {
+		return utils.NewError(fiber.StatusForbidden, utils.CodeForbidden, "Two-factor authentication can only be managed by its user")
+	}
+
+	return startEnrollment(c, user)
+}
+
+// ConfirmTwoFactor godoc
+// @Summary     Confirm two-factor enrollment
+// @Description Enable two-factor authentication with a first TOTP code, return one-time recovery codes
+// @Tags        two-factor
+// @Param       id   path int                true "User ID"
+// @Param       data body TwoFactorCodeInput true "TOTP code"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=object}
+// @Failure     400 {object} models.Response
+// @Failure     403 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Security    BearerAuth
+// @Router      /users/{id}/2fa/confirm [put]
+func ConfirmTwoFactor(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !isSelf(c, id) {
+		return utils.NewError(fiber.StatusForbidden, utils.CodeForbidden, "Two-factor authentication can only be managed by its user")
+	}
+
+	// Parse input from request body
+	var data TwoFactorCodeInput
+	if err := c.BodyParser(&data); err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+
+	userID, _ := strconv.ParseUint(id, 10, 32)
+	tf, err := findTwoFactor(uint(userID))
+	if err != nil || tf == nil || tf.EnabledAt != nil {
+		return utils.NewError(fiber.StatusNotFound, utils.CodeTwoFactorNotPending, "No pending two-factor enrollment")
+	}
+
+	if ok, err := useTOTP(tf, data.Code); !ok || err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeTwoFactorCodeInvalid, "Invalid two-factor code!")
+	}
+
+	codes, err := enableTwoFactor(tf)
+	if err != nil {
+		return utils.InternalError("Error when enabling two-factor authentication", err)
+	}
+
+	return respond(c, "Two-factor authentication enabled, store the recovery codes safely!", fiber.Map{
+		"recoveryCodes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary     Regenerate recovery codes
+// @Description Replace every recovery code of the current user, confirmed with a TOTP code
+// @Tags        two-factor
+// @Param       id   path int                true "User ID"
+// @Param       data body TwoFactorCodeInput true "TOTP code"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=object}
+// @Failure     400 {object} models.Response
+// @Failure     403 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Security    BearerAuth
+// @Router      /users/{id}/2fa/recovery-codes [put]
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !isSelf(c, id) {
+		return utils.NewError(fiber.StatusForbidden, utils.CodeForbidden, "Two-factor authentication can only be managed by its user")
+	}
+
+	// Parse input from request body
+	var data TwoFactorCodeInput
+	if err := c.BodyParser(&data); err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+
+	userID, _ := strconv.ParseUint(id, 10, 32)
+	tf, err := findTwoFactor(uint(userID))
+	if err != nil || tf == nil || tf.EnabledAt == nil {
+		return utils.NewError(fiber.StatusNotFound, utils.CodeTwoFactorNotEnabled, "Two-factor authentication is not enabled")
+	}
+
+	if ok, err := useTOTP(tf, data.Code); !ok || err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeTwoFactorCodeInvalid, "Invalid two-factor code!")
+	}
+
+	codes, err := replaceRecoveryCodes(database.GetAdminDB(), tf.UserID)
+	if err != nil {
+		return utils.InternalError("Error when generating recovery codes", err)
+	}
+
+	return respond(c, "Recovery codes regenerated, previous codes are no longer valid!", fiber.Map{
+		"recoveryCodes": codes,
+	})
+}
+
+// ResetTwoFactor godoc
+// @Summary     Reset two-factor authentication of a user
+// @Description Remove the TOTP secret and recovery codes of a user who lost their device.
+// @Description The user has to enroll again at next login if their role requires it
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       id  path     int true "User ID"
+// @Success     200 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id}/2fa [delete]
+func ResetTwoFactor(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	db := database.GetAdminDB()
+	if err := db.First(&user, id).Error; err != nil {
+		return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&models.TwoFactor{UserID: user.ID}).Delete(&models.TwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Where(&models.RecoveryCode{UserID: user.ID}).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return utils.InternalError("Error when resetting two-factor authentication of userID: "+id, err)
+	}
+
+	audit.Record(c, audit.Event{Action: audit.ActionUserTwoFactor, TargetType: "user", TargetID: id})
+
+	return respond(c, "Reset two-factor authentication successfully!", nil)
+}
+
+// SetRoleTwoFactor godoc
+// @Summary     Require two-factor authentication for a role
+// @Description Users with the role, directly or inherited, must login with a second factor
+// @Tags        roles
+// @Param       name path string             true "Role name"
+// @Param       data body RoleTwoFactorInput true "Whether 2FA is required"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response
+// @Failure     400 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/roles/{name}/2fa [put]
+func SetRoleTwoFactor(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	// Parse input from request body
+	var data RoleTwoFactorInput
+	if err := c.BodyParser(&data); err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+
+	if !roleExists(name) {
+		return utils.NewError(fiber.StatusNotFound, utils.CodeRoleNotFound, "Role not found")
+	}
+
+	if err := database.GetAdminDB().
+		Model(&models.Role{}).
+		Where(&models.Role{Name: name}).
+		Update("require_two_factor", data.Required).Error; err != nil {
+		return utils.InternalError("Error when updating role: "+name, err)
+	}
+
+	audit.Record(c, audit.Event{Action: audit.ActionRoleTwoFactor, TargetType: "role", TargetID: name, After: data})
+
+	return respond(c, "Update role two-factor requirement successfully!", nil)
+}
@@ -1,122 +1,292 @@
 package controllers
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/audit"
 	"github.com/pcminh0505/gofiber-casbin/api/models"
 	"github.com/pcminh0505/gofiber-casbin/api/utils"
 	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"github.com/pcminh0505/gofiber-casbin/infras/mail"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserInput struct {
-	Username string
-	Password string
-	Name     string
-	Email    string
-	Role     string
+	Username string `valid:"required~Username is required,matches(^[A-Za-z0-9_.-]+$)~Username can only have letters digits _ . and -,runelength(3|32)~Username must have 3 to 32 characters"`
+	Password string // Checked by the password policy
+	Name     string `valid:"runelength(0|100)~Name must have at most 100 characters"`
+	Email    string `valid:"email~Email is not a valid email address"`
+	Role     string `valid:"required~Role is required,role~Role is not defined"`
+}
+
+type UpdateUserInput struct {
+	Username string `valid:"required~Username is required,matches(^[A-Za-z0-9_.-]+$)~Username can only have letters digits _ . and -,runelength(3|32)~Username must have 3 to 32 characters"`
+	Name     string `valid:"runelength(0|100)~Name must have at most 100 characters"`
+	Email    string `valid:"email~Email is not a valid email address"`
+	// Replaces every role of the user if set, as before roles had their own endpoints
+	Role string `valid:"role~Role is not defined"`
+}
+
+// RoleAssignmentInput is a role of a user, removed at ExpiresAt if set.
+// A role name alone is accepted as an assignment without expiry.
+type RoleAssignmentInput struct {
+	Role      string
+	ExpiresAt *time.Time
+}
+
+type UserRolesInput struct {
+	Roles []RoleAssignmentInput
+}
+
+// AddUserRoleInput assigns a role until ExpiresAt, or for ExpiresIn (Eg. 8h)
+type AddUserRoleInput struct {
+	Role      string
+	ExpiresAt *time.Time
+	ExpiresIn string
 }
 
 type UpdatePasswordInput struct {
-	CurrentPassword string
-	NewPassword     string
+	CurrentPassword string `valid:"required~Current password is required"`
+	NewPassword     string `valid:"required~New password is required"`
+}
+
+// UserQuery filters the users listing. Username and email match partially, case insensitive.
+type UserQuery struct {
+	PageInput
+	Role        string `query:"role"`
+	Username    string `query:"username"`
+	Email       string `query:"email"`
+	CreatedFrom string `query:"createdFrom"` // Inclusive
+	CreatedTo   string `query:"createdTo"`   // Exclusive
+	Deleted     bool   `query:"deleted"`     // List deleted users instead, to restore them
+}
+
+// UnmarshalJSON accepts a role name or a {role, expiresAt} object
+func (r *RoleAssignmentInput) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*r = RoleAssignmentInput{Role: name}
+		return nil
+	}
+
+	type assignment RoleAssignmentInput
+	return json.Unmarshal(b, (*assignment)(r))
+}
+
+// userSortColumns maps the sortable fields of users to their column
+var userSortColumns = map[string]string{
+	"id":        "id",
+	"username":  "username",
+	"name":      "name",
+	"email":     "email",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
 }
 
 // GetUsers godoc
-// @Summary Get all users
-// @Tags    users
-// @Accept  json
-// @Produce json
-// @Success 200 {array}  models.User
-// @Failure 401 {object} models.Response
-// @Failure 404 {object} models.Response
-// @Router  /admin/users/ [get]
+// @Summary     Get users
+// @Description Get a page of users matching the filters. Pages are requested by offset, or by the
+// @Description nextCursor of the previous page which is not shifted by inserted users
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       limit       query    int    false "Page size (default 20, max 100)"
+// @Param       offset      query    int    false "Rows to skip, ignored with cursor"
+// @Param       cursor      query    string false "nextCursor of the previous page"
+// @Param       sort        query    string false "id, username, name, email, createdAt or updatedAt, descending if prefixed by - (default id)"
+// @Param       role        query    string false "Has role"
+// @Param       username    query    string false "Username contains"
+// @Param       email       query    string false "Email contains"
+// @Param       createdFrom query    string false "Created at or after (2006-01-02 or RFC 3339)"
+// @Param       createdTo   query    string false "Created before (2006-01-02 or RFC 3339)"
+// @Param       deleted     query    bool   false "List deleted users instead"
+// @Success     200         {object} models.Response{data=models.UserPage}
+// @Failure     400         {object} models.Response
+// @Failure     401         {object} models.Response
+// @Failure     500         {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/ [get]
 func GetUsers(c *fiber.Ctx) error {
-	var users []models.User
-	if err := database.GetAdminDB().Find(&users).Error; err != nil {
-		c.Status(fiber.StatusInternalServerError)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Internal Server Error",
-		})
+	var q UserQuery
+	if err := c.QueryParser(&q); err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+	}
+	if err := validateInput(&q); err != nil {
+		return err
+	}
+
+	field, column, desc, err := q.sortColumn(userSortColumns, "id")
+	if err != nil {
+		return err
+	}
+
+	query := database.GetAdminDB().Model(&models.User{})
+	if q.Deleted {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	// Expired roles are left until swept, they are not roles of the user anymore
+	now := time.Now()
+	if q.Role != "" {
+		query = query.Where("id IN (?)", database.GetAdminDB().
+			Model(&models.UserRole{}).
+			Select("user_id").
+			Where(&models.UserRole{Role: q.Role}).
+			Where("expires_at IS NULL OR expires_at > ?", now))
+	}
+	if q.Username != "" {
+		query = query.Where("username ILIKE ?", likePattern(q.Username))
+	}
+	if q.Email != "" {
+		query = query.Where("email ILIKE ?", likePattern(q.Email))
+	}
+	if q.CreatedFrom != "" {
+		from, err := parseQueryTime("createdFrom", q.CreatedFrom)
+		if err != nil {
+			return err
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if q.CreatedTo != "" {
+		to, err := parseQueryTime("createdTo", q.CreatedTo)
+		if err != nil {
+			return err
+		}
+		query = query.Where("created_at < ?", to)
 	}
 
-	return c.JSON(users)
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	paged, err := q.paginate(query, column, desc)
+	if err != nil {
+		return err
+	}
+	users := []models.User{}
+	if err := paged.Preload("Roles", "expires_at IS NULL OR expires_at > ?", now).Find(&users).Error; err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	page := models.UserPage{
+		Data: users,
+		Pagination: models.Pagination{
+			Total:  total,
+			Limit:  q.limit(),
+			Offset: q.Offset,
+		},
+	}
+	if len(users) > q.limit() {
+		page.Data = users[:q.limit()]
+		last := page.Data[len(page.Data)-1]
+		page.Pagination.NextCursor = encodeCursor(pageCursor{Sort: q.Sort, Value: userSortValue(last, field), ID: last.ID})
+	}
+	if q.Cursor != "" {
+		page.Pagination.Offset = 0
+	}
+
+	return respond(c, "", page)
+}
+
+// userSortValue returns the value of a sort field of a user, for cursors
+func userSortValue(user models.User, field string) string {
+	switch field {
+	case "username":
+		return user.Username
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	case "createdAt":
+		return cursorTime(user.CreatedAt)
+	case "updatedAt":
+		return cursorTime(user.UpdatedAt)
+	}
+	return strconv.Itoa(int(user.ID))
 }
 
 // GetUser godoc
-// @Summary Get a user by ID
-// @Tags    users
-// @Accept  json
-// @Produce json
-// @Param   id  path     int true "User ID"
-// @Success 200 {object} models.User
-// @Failure 404 {object} models.Response
-// @Router  /admin/users/{id} [get]
+// @Summary  Get a user by ID
+// @Tags     users
+// @Accept   json
+// @Produce  json
+// @Param    id  path     int true "User ID"
+// @Success  200 {object} models.Response{data=models.User}
+// @Failure  404 {object} models.Response
+// @Security BearerAuth
+// @Router   /admin/users/{id} [get]
 func GetUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var user models.User
-	err := database.GetAdminDB().First(&user, id).Error
+	err := database.GetAdminDB().Preload("Roles", "expires_at IS NULL OR expires_at > ?", time.Now()).First(&user, id).Error
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		c.Status(fiber.StatusNotFound)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "User not found",
-		})
+		return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found")
+	} else if err != nil {
+		return utils.InternalError("Internal Server Error", err)
 	}
 
-	return c.JSON(user)
+	return respond(c, "", user)
 }
 
 // CreateUser godoc
 // @Summary     Create new user
-// @Description Create new user with username, password, name, email, and role
+// @Description Create new user with username, password, name, email, and role. A verification link is emailed.
+// @Description Password must meet the password policy, broken rules are returned as violations
 // @Tags        users
 // @Param       data body UserInput true "Enter user's info"
 // @Accept      json
 // @Produce     json
-// @Success     200 {object} models.Response
+// @Success     200 {object} models.Response{data=models.User}
 // @Failure     400 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
 // @Router      /admin/users/ [post]
-func CreateUser(e *casbin.Enforcer) fiber.Handler {
+func CreateUser(e *casbin.SyncedEnforcer, m mail.Mailer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Parse input from request body
 		var data UserInput
 		if err := c.BodyParser(&data); err != nil {
-			c.Status(fiber.StatusBadRequest)
-			return c.JSON(fiber.Map{
-				"error":   true,
-				"message": "Invalid request params!",
-			})
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
 		}
 
-		// If existed user is found, return error
-		if count := database.GetAdminDB().
-			Where(&models.User{Email: data.Email}).
-			First(new(models.User)).
-			RowsAffected; count > 0 {
-			c.Status(fiber.StatusBadRequest)
-			return c.JSON(fiber.Map{
-				"error":   true,
-				"message": "Email is already registered",
-			})
+		if err := validateInput(&data); err != nil {
+			return err
 		}
 
-		if count := database.GetAdminDB().
-			Where(&models.User{Username: data.Username}).
+		// If existed user is found, return error. Deleted users keep their email and username until purged.
+		// Email is optional, conditions are explicit since an empty struct field would match every user.
+		if data.Email != "" {
+			if count := database.GetAdminDB().Unscoped().
+				Where("email = ?", data.Email).
+				First(new(models.User)).
+				RowsAffected; count > 0 {
+				return utils.NewError(fiber.StatusBadRequest, utils.CodeEmailTaken, "Email is already registered")
+			}
+		}
+
+		if count := database.GetAdminDB().Unscoped().
+			Where("username = ?", data.Username).
 			First(new(models.User)).
 			RowsAffected; count > 0 {
-			c.Status(fiber.StatusBadRequest)
-			return c.JSON(fiber.Map{
-				"error":   true,
-				"message": "Username is already registered",
-			})
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeUsernameTaken, "Username is already registered")
+		}
+
+		// If password does not meet the policy, return the broken rules
+		violations, err := validatePassword(data.Password, models.User{Username: data.Username, Email: data.Email})
+		if err != nil {
+			return utils.InternalError("Internal Server Error", err)
+		}
+		if len(violations) > 0 {
+			return passwordViolations("Password", violations)
 		}
 
 		// Encrypt password and push to AdminDB
@@ -127,7 +297,6 @@ func CreateUser(e *casbin.Enforcer) fiber.Handler {
 			Password: string(password),
 			Name:     data.Name,
 			Email:    data.Email,
-			Role:     data.Role,
 		}
 
 		if user.CreatedAt.IsZero() {
@@ -135,208 +304,620 @@ func CreateUser(e *casbin.Enforcer) fiber.Handler {
 		}
 		user.UpdatedAt = time.Now()
 
-		// Write into user DB
-		database.GetAdminDB().Create(&user)
-		// Write into Casbin rule DB
-		e.AddGroupingPolicy(fmt.Sprint(user.ID), user.Role)
+		// Write user and its Casbin role in one transaction, a user never exists without its role
+		if err := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			if err := savePasswordHistory(tx, user.ID, user.Password); err != nil {
+				return err
+			}
 
-		return c.JSON(fiber.Map{
-			"error":   false,
-			"message": "New user registered successfully!",
-			"user":    user,
-		})
+			role := models.UserRole{UserID: user.ID, Role: data.Role}
+			if err := tx.Create(&role).Error; err != nil {
+				return err
+			}
+			user.Roles = []models.UserRole{role}
+			return policy.AddGroupingPolicy(fmt.Sprint(user.ID), role.Role)
+		}); err != nil {
+			return utils.InternalError("Error when creating user: "+data.Username, err)
+		}
+
+		// User starts unverified until the link of the email is opened
+		sendEmailVerification(m, user)
+
+		audit.Record(c, audit.Event{Action: audit.ActionUserCreate, TargetType: "user", TargetID: fmt.Sprint(user.ID), After: user})
+
+		return respond(c, "New user registered successfully!", user)
 	}
 }
 
 // UpdateUser godoc
 // @Summary     Update user's information
-// @Description Update username, name, email, and role
+// @Description Update username, name, and email. Role replaces every role of the user if set,
+// @Description roles are otherwise assigned with /admin/users/{id}/roles
 // @Tags        users
-// @Param       id   path int       true "User ID"
-// @Param       data body UserInput true "Enter user's info"
+// @Param       id   path int             true "User ID"
+// @Param       data body UpdateUserInput true "Enter user's info"
 // @Accept      json
 // @Produce     json
-// @Success     200 {object} models.Response
+// @Success     200 {object} models.Response{data=models.User}
 // @Failure     400 {object} models.Response
 // @Failure     404 {object} models.Response
 // @Failure     500 {object} models.Response
+// @Security    BearerAuth
 // @Router      /admin/users/{id} [put]
-func UpdateUser(e *casbin.Enforcer) fiber.Handler {
+func UpdateUser(e *casbin.SyncedEnforcer, m mail.Mailer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Parse input from request body
-		var data UserInput
+		var data UpdateUserInput
 		if err := c.BodyParser(&data); err != nil {
-			c.Status(fiber.StatusBadRequest)
-			return c.JSON(fiber.Map{
-				"error":   true,
-				"message": "Invalid request params!",
-			})
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
 		}
 
-		db := database.GetAdminDB()
+		if err := validateInput(&data); err != nil {
+			return err
+		}
 
-		var user models.User
+		var user, before models.User
 		id := c.Params("id")
+		emailChanged := false
 
-		res := db.Transaction(func(tx *gorm.DB) error {
-			// Update role
-			_, err := e.UpdateGroupingPolicy([]string{id, user.Role}, []string{id, data.Role})
-			if err != nil {
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			// Find and Update user's info
+			if err := tx.First(&user, id).Error; err != nil {
 				return err
 			}
+			before = user
+			emailChanged = data.Email != "" && data.Email != user.Email
 
-			// Find and Update user's info
-			if err := tx.First(&user, id).
+			if err := tx.Model(&user).
 				Updates(models.User{
 					Name:     data.Name,
 					Email:    data.Email,
 					Username: data.Username,
-					Role:     data.Role,
 				}).Error; err != nil {
 				return err
 			}
 
-			return nil
+			// New email has to be verified again
+			if emailChanged {
+				if err := tx.Model(&user).Update("email_verified_at", nil).Error; err != nil {
+					return err
+				}
+			}
+
+			if data.Role == "" {
+				return nil
+			}
+			var err error
+			if before.Roles, err = activeUserRoles(tx, user.ID); err != nil {
+				return err
+			}
+			user.Roles = []models.UserRole{{Role: data.Role}}
+			_, _, err = replaceUserRoles(tx, policy, user.ID, user.Roles)
+			return err
 		})
 
-		if res != nil {
-			c.Status(fiber.StatusInternalServerError)
-			return c.JSON(fiber.Map{
-				"error":   true,
-				"message": "Error updating userID: " + id,
-			})
+		if errors.Is(res, gorm.ErrRecordNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+		} else if res != nil {
+			return utils.InternalError("Error updating userID: "+id, res)
 		}
 
-		return c.JSON(fiber.Map{
-			"error":   false,
-			"message": "Update user successfully!",
-			"user":    user,
+		if emailChanged {
+			sendEmailVerification(m, user)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionUserUpdate, TargetType: "user", TargetID: id, Before: before, After: user})
+
+		return respond(c, "Update user successfully!", user)
+	}
+}
+
+// GetUserRoles godoc
+// @Summary     Get roles of a user
+// @Description Get the roles assigned to a user, with their expiry
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       id  path     int true "User ID"
+// @Success     200 {object} models.Response{data=[]models.UserRole}
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id}/roles [get]
+func GetUserRoles(c *fiber.Ctx) error {
+	db := database.GetAdminDB()
+
+	var user models.User
+	if err := db.First(&user, c.Params("id")).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+	} else if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+
+	roles, err := activeUserRoles(db, user.ID)
+	if err != nil {
+		return utils.InternalError("Error when reading roles of userID: "+c.Params("id"), err)
+	}
+
+	return respond(c, "", roles)
+}
+
+// SetUserRoles godoc
+// @Summary     Set roles of a user
+// @Description Replace the roles of a user by the given set, only the differences are written.
+// @Description A role with expiresAt is removed at that time, roles are given by name or as {role, expiresAt}
+// @Tags        users
+// @Param       id   path int            true "User ID"
+// @Param       data body UserRolesInput true "Every role the user should have"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=object}
+// @Failure     400 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id}/roles [put]
+func SetUserRoles(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		// Parse input from request body
+		var data UserRolesInput
+		if err := c.BodyParser(&data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+		}
+
+		roles, err := validateRoles(data.Roles)
+		if err != nil {
+			return err
+		}
+
+		var user models.User
+		var previous []models.UserRole
+		var added, removed []string
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			if err := tx.First(&user, id).Error; err != nil {
+				return err
+			}
+			if previous, err = activeUserRoles(tx, user.ID); err != nil {
+				return err
+			}
+
+			added, removed, err = replaceUserRoles(tx, policy, user.ID, roles)
+			return err
+		})
+
+		if errors.Is(res, gorm.ErrRecordNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+		} else if res != nil {
+			return utils.InternalError("Error when updating roles of userID: "+id, res)
+		}
+
+		audit.Record(c, audit.Event{
+			Action:     audit.ActionUserRoles,
+			TargetType: "user",
+			TargetID:   id,
+			Before:     fiber.Map{"roles": previous},
+			After:      fiber.Map{"roles": roles},
+		})
+
+		return respond(c, "Update user roles successfully!", fiber.Map{
+			"roles":   roles,
+			"added":   added,
+			"removed": removed,
 		})
 	}
 }
 
+// AddUserRole godoc
+// @Summary     Assign a role to a user
+// @Description Assign a role, other roles of the user are kept. With expiresAt or expiresIn (Eg. 8h) the role is
+// @Description removed at that time, the expiry of an assigned role is replaced
+// @Tags        users
+// @Param       id   path int              true "User ID"
+// @Param       data body AddUserRoleInput true "Role to assign"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=models.UserRole}
+// @Failure     400 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id}/roles [post]
+func AddUserRole(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		// Parse input from request body
+		var data AddUserRoleInput
+		if err := c.BodyParser(&data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+		}
+
+		assignment := RoleAssignmentInput{Role: data.Role, ExpiresAt: data.ExpiresAt}
+		if data.ExpiresIn != "" {
+			ttl, err := time.ParseDuration(data.ExpiresIn)
+			if err != nil || ttl <= 0 || data.ExpiresAt != nil {
+				return utils.InvalidInput([]utils.FieldError{{Field: "ExpiresIn", Rule: "duration", Message: "ExpiresIn must be a positive duration (Eg. 8h), without expiresAt"}})
+			}
+			expiresAt := time.Now().Add(ttl)
+			assignment.ExpiresAt = &expiresAt
+		}
+
+		roles, err := validateRoles([]RoleAssignmentInput{assignment})
+		if err != nil {
+			return err
+		}
+		role := roles[0]
+
+		var user models.User
+		var previous *models.UserRole
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			if err := tx.First(&user, id).Error; err != nil {
+				return err
+			}
+
+			var current models.UserRole
+			if tx.Where(&models.UserRole{UserID: user.ID, Role: role.Role}).Limit(1).Find(&current).RowsAffected > 0 {
+				previous = &current
+			}
+
+			role.UserID = user.ID
+			if err := saveUserRole(tx, policy, &role); err != nil {
+				return err
+			}
+			return policy.AddGroupingPolicy(fmt.Sprint(user.ID), role.Role)
+		})
+
+		if errors.Is(res, gorm.ErrRecordNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+		} else if res != nil {
+			return utils.InternalError("Error when assigning role to userID: "+id, res)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionUserRoleAdd, TargetType: "user", TargetID: id, Before: previous, After: role})
+
+		return respond(c, "Assign user role successfully!", role)
+	}
+}
+
+// validateRoles deduplicates role assignments, failing if there is none, a role is not defined
+// or an expiry is not in the future
+func validateRoles(assignments []RoleAssignmentInput) ([]models.UserRole, error) {
+	var fields []utils.FieldError
+	if len(assignments) == 0 {
+		fields = append(fields, utils.FieldError{Field: "Roles", Rule: "required", Message: "At least one role is required"})
+	}
+
+	now := time.Now()
+	roles := make([]models.UserRole, 0, len(assignments))
+	seen := make(map[string]bool, len(assignments))
+	for _, assignment := range assignments {
+		if seen[assignment.Role] {
+			continue
+		}
+		seen[assignment.Role] = true
+
+		if !roleExists(assignment.Role) {
+			fields = append(fields, utils.FieldError{Field: "Roles", Rule: "role", Message: "Role not found: " + assignment.Role})
+			continue
+		}
+		if assignment.ExpiresAt != nil && !assignment.ExpiresAt.After(now) {
+			fields = append(fields, utils.FieldError{Field: "ExpiresAt", Rule: "future", Message: "Expiry must be in the future: " + assignment.Role})
+			continue
+		}
+		roles = append(roles, models.UserRole{Role: assignment.Role, ExpiresAt: assignment.ExpiresAt})
+	}
+
+	if len(fields) > 0 {
+		return nil, utils.InvalidInput(fields)
+	}
+	return roles, nil
+}
+
+// activeUserRoles lists the roles of a user which have not expired
+func activeUserRoles(db *gorm.DB, userID uint) ([]models.UserRole, error) {
+	roles := []models.UserRole{}
+	err := db.
+		Where("user_id = ? AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Order("role").
+		Find(&roles).Error
+	return roles, err
+}
+
+// replaceUserRoles replaces the roles of a user by the given ones, only the differences are written.
+// Returns the roles added and removed.
+func replaceUserRoles(tx *gorm.DB, policy *database.PolicyTx, userID uint, roles []models.UserRole) ([]string, []string, error) {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Role)
+	}
+
+	if err := tx.Where("user_id = ? AND role NOT IN ?", userID, names).Delete(&models.UserRole{}).Error; err != nil {
+		return nil, nil, err
+	}
+	for i := range roles {
+		roles[i].UserID = userID
+		if err := saveUserRole(tx, policy, &roles[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return policy.SetRoles(fmt.Sprint(userID), names)
+}
+
+// saveUserRole assigns a role to a user, replacing the expiry if it is already assigned.
+// The grouping is left to the caller.
+func saveUserRole(tx *gorm.DB, policy *database.PolicyTx, role *models.UserRole) error {
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "role"}},
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+	}).Create(role).Error; err != nil {
+		return err
+	}
+	policy.SetRoleExpiry(fmt.Sprint(role.UserID), role.Role, role.ExpiresAt)
+	return nil
+}
+
 // DeleteUser godoc
-// @Summary Delete user
-// @Tags    users
-// @Accept  json
-// @Produce json
-// @Param   id  path     int true "User ID"
-// @Success 200 {object} models.Response
-// @Failure 400 {object} models.Response
-// @Router  /admin/users/{id} [delete]
-func DeleteUser(e *casbin.Enforcer) fiber.Handler {
+// @Summary     Delete user
+// @Description Delete user, who can no longer login nor access anything. The user can be restored
+// @Description until purged after USER_RETENTION
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       id  path     int true "User ID"
+// @Success     200 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id} [delete]
+func DeleteUser(e *casbin.SyncedEnforcer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
 		db := database.GetAdminDB()
 
-		res := db.Transaction(func(tx *gorm.DB) error {
-			if err := tx.Delete(models.User{}, id).Error; err != nil {
+		var user models.User
+		if err := db.First(&user, id).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+		} else if err != nil {
+			return utils.InternalError("Internal Server Error", err)
+		}
+
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			// Soft delete, the roles stay in user_roles to be restored
+			if err := tx.Delete(&user).Error; err != nil {
 				return err
 			}
 
-			_, err := e.RemoveGroupingPolicy(id)
-			if err != nil {
+			return policy.RemoveFilteredGroupingPolicy(0, fmt.Sprint(user.ID))
+		})
+
+		if res != nil {
+			return utils.InternalError("Error when deleting userID: "+id, res)
+		}
+
+		audit.Record(c, audit.Event{Action: audit.ActionUserDelete, TargetType: "user", TargetID: id, Before: user})
+
+		// Deleted user must not keep using existing tokens, routes without Casbin check would still accept them
+		if err := revokeUserSessions(user.ID); err != nil {
+			return utils.InternalError("Error when revoking sessions of userID: "+id, err)
+		}
+
+		return respond(c, "Delete user successfully!", nil)
+	}
+}
+
+// RestoreUser godoc
+// @Summary     Restore deleted user
+// @Description Restore a deleted user with its roles which have not expired, until purged after USER_RETENTION
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       id  path     int true "User ID"
+// @Success     200 {object} models.Response{data=models.User}
+// @Failure     400 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id}/restore [post]
+func RestoreUser(e *casbin.SyncedEnforcer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+
+		db := database.GetAdminDB()
+
+		var user models.User
+		if err := db.Unscoped().Where("deleted_at IS NOT NULL").First(&user, id).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "Deleted user not found!")
+		} else if err != nil {
+			return utils.InternalError("Internal Server Error", err)
+		}
+
+		res := database.CasbinTransaction(e, func(tx *gorm.DB, policy *database.PolicyTx) error {
+			if err := tx.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
 				return err
 			}
 
+			roles, err := activeUserRoles(tx, user.ID)
+			if err != nil {
+				return err
+			}
+			for _, role := range roles {
+				if err := policy.AddGroupingPolicy(fmt.Sprint(user.ID), role.Role); err != nil {
+					return err
+				}
+				policy.SetRoleExpiry(fmt.Sprint(user.ID), role.Role, role.ExpiresAt)
+			}
+			user.Roles = roles
 			return nil
 		})
 
 		if res != nil {
-			c.Status(fiber.StatusInternalServerError)
-			return c.JSON(fiber.Map{
-				"error":   true,
-				"message": "Error when deleting userID: " + id,
-			})
+			return utils.InternalError("Error when restoring userID: "+id, res)
 		}
 
-		return c.JSON(fiber.Map{
-			"error":   false,
-			"message": "Delete user successfully!",
-		})
+		audit.Record(c, audit.Event{Action: audit.ActionUserRestore, TargetType: "user", TargetID: id, After: user})
+
+		return respond(c, "Restore user successfully!", user)
 	}
 }
 
+// PurgeUsers godoc
+// @Summary     Purge deleted users
+// @Description Permanently remove users deleted for longer than USER_RETENTION, also done every USER_PURGE_INTERVAL
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response{data=object}
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/purge [delete]
+func PurgeUsers(c *fiber.Ctx) error {
+	purged, err := database.PurgeDeletedUsers(time.Now().Add(-database.UserRetention()))
+	if err != nil {
+		return utils.InternalError("Error when purging deleted users", err)
+	}
+
+	audit.Record(c, audit.Event{Action: audit.ActionUserPurge, TargetType: "user", After: fiber.Map{"purged": purged}})
+
+	return respond(c, "Purge deleted users successfully!", fiber.Map{
+		"purged": purged,
+	})
+}
+
 // UpdatePassword godoc
 // @Summary     Update user's password
-// @Description Update password
+// @Description Update password, the new password must meet the password policy and differ from the last ones
 // @Tags        users
 // @Param       id   path int                 true "User ID"
 // @Param       data body UpdatePasswordInput true "Enter user's info"
 // @Accept      json
 // @Produce     json
-// @Success     200 {object} models.Response
+// @Success     200 {object} models.Response{data=models.User}
 // @Failure     400 {object} models.Response
 // @Failure     404 {object} models.Response
 // @Failure     500 {object} models.Response
+// @Security    BearerAuth
 // @Router      /users/{id}/password [put]
 func UpdatePassword(c *fiber.Ctx) error {
 
 	// Parse input from request body
 	var data UpdatePasswordInput
 	if err := c.BodyParser(&data); err != nil {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Invalid request params!",
-		})
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
 	}
 
 	var user models.User
 	id := c.Params("id")
 
-	if utils.IsEmpty(data.CurrentPassword) || utils.IsEmpty(data.NewPassword) {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Cannot proceed with empty input!",
-		})
+	if err := validateInput(&data); err != nil {
+		return err
 	}
 
 	if data.CurrentPassword == data.NewPassword {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Updated password must be different from the current one!",
-		})
+		return utils.NewError(fiber.StatusBadRequest, utils.CodePasswordUnchanged, "Updated password must be different from the current one!")
 	}
 
 	db := database.GetAdminDB()
 
 	// If user is not found, return error
 	if err := db.First(&user, id).Error; err != nil {
-		c.Status(fiber.StatusNotFound)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "User not found!",
-		})
+		return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
 	}
 
 	// If current password is incorrect, return error
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(data.CurrentPassword)); err != nil {
-		c.Status(fiber.StatusBadRequest)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Incorrect password!",
-		})
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeIncorrectPassword, "Incorrect password!")
+	}
+
+	// If new password does not meet the policy or was used recently, return the broken rules
+	violations, err := validatePassword(data.NewPassword, user)
+	if err != nil {
+		return utils.InternalError("Internal Server Error", err)
+	}
+	if len(violations) > 0 {
+		return passwordViolations("NewPassword", violations)
 	}
 
 	// Update password
 	newPassword, _ := bcrypt.GenerateFromPassword([]byte(data.NewPassword), bcrypt.DefaultCost)
-	if err := db.Model(&user).Update("password", string(newPassword)).Error; err != nil {
-		c.Status(fiber.StatusInternalServerError)
-		return c.JSON(fiber.Map{
-			"error":   true,
-			"message": "Error updating password userID: " + id,
-		})
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", string(newPassword)).Error; err != nil {
+			return err
+		}
+		return savePasswordHistory(tx, user.ID, string(newPassword))
+	}); err != nil {
+		return utils.InternalError("Error updating password userID: "+id, err)
 	}
 
-	fmt.Println(data)
+	// Password changed, every existing session has to login again
+	if err := revokeUserSessions(user.ID); err != nil {
+		return utils.InternalError("Error when revoking sessions of userID: "+id, err)
+	}
 
-	return c.JSON(fiber.Map{
-		"error":   false,
-		"message": "Update password successfully!",
-		"user":    user,
-	})
+	audit.Record(c, audit.Event{Action: audit.ActionUserPassword, TargetType: "user", TargetID: id})
+
+	return respond(c, "Update password successfully!", user)
+}
+
+// RevokeUserSessions godoc
+// @Summary     Revoke all sessions of a user
+// @Description Revoke every access token and refresh token of a user, forcing them to login again
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       id  path     int true "User ID"
+// @Success     200 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id}/sessions [delete]
+func RevokeUserSessions(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if err := database.GetAdminDB().First(&user, id).Error; err != nil {
+		return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+	}
+
+	if err := revokeUserSessions(user.ID); err != nil {
+		return utils.InternalError("Error when revoking sessions of userID: "+id, err)
+	}
+
+	audit.Record(c, audit.Event{Action: audit.ActionUserSessions, TargetType: "user", TargetID: id})
+
+	return respond(c, "Revoke sessions successfully!", nil)
+}
+
+// UnlockUser godoc
+// @Summary     Unlock a user
+// @Description Clear failed login attempts and lockout of a user
+// @Tags        users
+// @Accept      json
+// @Produce     json
+// @Param       id  path     int true "User ID"
+// @Success     200 {object} models.Response
+// @Failure     404 {object} models.Response
+// @Failure     500 {object} models.Response
+// @Security    BearerAuth
+// @Router      /admin/users/{id}/lock [delete]
+func UnlockUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user models.User
+	if err := database.GetAdminDB().First(&user, id).Error; err != nil {
+		return utils.NewError(fiber.StatusNotFound, utils.CodeUserNotFound, "User not found!")
+	}
+
+	if err := database.ResetLoginFailures(accountLockKey(user.ID)); err != nil {
+		return utils.InternalError("Error when unlocking userID: "+id, err)
+	}
+
+	audit.Record(c, audit.Event{Action: audit.ActionUserUnlock, TargetType: "user", TargetID: id})
+
+	return respond(c, "Unlock user successfully!", nil)
 }
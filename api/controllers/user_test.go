@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"github.com/pcminh0505/gofiber-casbin/middleware"
+	"gorm.io/gorm"
+)
+
+// newRolesTestApp serves SetUserRoles on a fresh SQLite AdminDB with the roles admin, user and oncall
+func newRolesTestApp(t *testing.T) (*fiber.App, *casbin.SyncedEnforcer, *gorm.DB) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "admin.db")
+	db, err := gorm.Open(sqlite.Open(path+"?_pragma=busy_timeout(5000)"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Role{}, &models.UserRole{}, &models.PasswordHistory{}, &models.AuditEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"admin", "user", "oncall"} {
+		if err := db.Create(&models.Role{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.SetAdminDB(db)
+
+	adapter, err := gormadapter.NewAdapterByDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := casbin.NewSyncedEnforcer("../../config/restful_rbac_model.conf", adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: middleware.NewErrorHandler(middleware.ErrorSettings{})})
+	app.Put("/api/admin/users/:id/roles", SetUserRoles(e))
+	return app, e, db
+}
+
+// createTestUser creates a user with the given roles
+func createTestUser(t *testing.T, e *casbin.SyncedEnforcer, db *gorm.DB, roles ...string) models.User {
+	t.Helper()
+
+	user := models.User{Username: "alice"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range roles {
+		if err := db.Create(&models.UserRole{UserID: user.ID, Role: role}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.AddGroupingPolicy(fmt.Sprint(user.ID), role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+type setRolesResponse struct {
+	Error bool
+	Code  string
+	Data  struct {
+		Added   []string
+		Removed []string
+	}
+}
+
+// putRoles sends PUT /api/admin/users/:id/roles with a raw JSON body
+func putRoles(t *testing.T, app *fiber.App, id interface{}, body string) (int, setRolesResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(fiber.MethodPut, fmt.Sprintf("/api/admin/users/%v/roles", id), strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var data setRolesResponse
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, data
+}
+
+// storedRoles lists the roles of a user in user_roles
+func storedRoles(t *testing.T, db *gorm.DB, userID uint) []string {
+	t.Helper()
+
+	var roles []string
+	if err := db.Model(&models.UserRole{}).Where("user_id = ?", userID).Order("role").Pluck("role", &roles).Error; err != nil {
+		t.Fatal(err)
+	}
+	return roles
+}
+
+// enforcedRoles lists the roles of a user in the enforcer
+func enforcedRoles(t *testing.T, e *casbin.SyncedEnforcer, userID uint) []string {
+	t.Helper()
+
+	roles, err := e.GetRolesForUser(fmt.Sprint(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return roles
+}
+
+func TestSetUserRolesReplaces(t *testing.T) {
+	app, e, db := newRolesTestApp(t)
+	user := createTestUser(t, e, db, "user")
+
+	status, res := putRoles(t, app, user.ID, `{"roles": [{"role": "admin"}, {"role": "oncall"}]}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", status, res.Code)
+	}
+	if fmt.Sprint(res.Data.Added) != "[admin oncall]" || fmt.Sprint(res.Data.Removed) != "[user]" {
+		t.Errorf("unexpected diff: added %v, removed %v", res.Data.Added, res.Data.Removed)
+	}
+	if roles := storedRoles(t, db, user.ID); fmt.Sprint(roles) != "[admin oncall]" {
+		t.Errorf("unexpected user_roles: %v", roles)
+	}
+	if roles := enforcedRoles(t, e, user.ID); len(roles) != 2 || !e.HasGroupingPolicy(fmt.Sprint(user.ID), "admin") || !e.HasGroupingPolicy(fmt.Sprint(user.ID), "oncall") {
+		t.Errorf("unexpected roles in the enforcer: %v", roles)
+	}
+}
+
+func TestSetUserRolesUnchanged(t *testing.T) {
+	app, e, db := newRolesTestApp(t)
+	user := createTestUser(t, e, db, "admin", "user")
+
+	status, res := putRoles(t, app, user.ID, `{"roles": [{"role": "user"}, {"role": "admin"}]}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d (%s)", status, res.Code)
+	}
+	if len(res.Data.Added) != 0 || len(res.Data.Removed) != 0 {
+		t.Errorf("expected no change: added %v, removed %v", res.Data.Added, res.Data.Removed)
+	}
+	if roles := storedRoles(t, db, user.ID); fmt.Sprint(roles) != "[admin user]" {
+		t.Errorf("unexpected user_roles: %v", roles)
+	}
+	if roles := enforcedRoles(t, e, user.ID); len(roles) != 2 {
+		t.Errorf("unexpected roles in the enforcer: %v", roles)
+	}
+}
+
+func TestSetUserRolesUnknownRole(t *testing.T) {
+	app, e, db := newRolesTestApp(t)
+	user := createTestUser(t, e, db, "user")
+
+	status, res := putRoles(t, app, user.ID, `{"roles": [{"role": "admin"}, {"role": "ghost"}]}`)
+	if status != fiber.StatusBadRequest || res.Code != "invalid_input" {
+		t.Fatalf("expected 400 invalid_input, got %d (%s)", status, res.Code)
+	}
+	if roles := storedRoles(t, db, user.ID); fmt.Sprint(roles) != "[user]" {
+		t.Errorf("user_roles changed: %v", roles)
+	}
+	if roles := enforcedRoles(t, e, user.ID); fmt.Sprint(roles) != "[user]" {
+		t.Errorf("roles in the enforcer changed: %v", roles)
+	}
+}
+
+func TestSetUserRolesUnknownUser(t *testing.T) {
+	app, _, db := newRolesTestApp(t)
+
+	status, res := putRoles(t, app, 42, `{"roles": [{"role": "admin"}]}`)
+	if status != fiber.StatusNotFound || res.Code != "user_not_found" {
+		t.Fatalf("expected 404 user_not_found, got %d (%s)", status, res.Code)
+	}
+
+	var count int64
+	db.Model(&models.UserRole{}).Count(&count)
+	if count != 0 {
+		t.Errorf("user_roles rows created for an unknown user: %d", count)
+	}
+}
+
+func TestUpdateUserReplacesRoles(t *testing.T) {
+	app, e, db := newRolesTestApp(t)
+	app.Put("/api/admin/users/:id", UpdateUser(e, nil))
+	user := createTestUser(t, e, db, "admin", "user")
+
+	req := httptest.NewRequest(fiber.MethodPut, fmt.Sprintf("/api/admin/users/%d", user.ID), strings.NewReader(`{"username": "alice", "role": "oncall"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	if roles := storedRoles(t, db, user.ID); fmt.Sprint(roles) != "[oncall]" {
+		t.Errorf("unexpected user_roles: %v", roles)
+	}
+	if roles := enforcedRoles(t, e, user.ID); fmt.Sprint(roles) != "[oncall]" {
+		t.Errorf("unexpected roles in the enforcer: %v", roles)
+	}
+
+	// Unknown role is rejected, not ignored
+	req = httptest.NewRequest(fiber.MethodPut, fmt.Sprintf("/api/admin/users/%d", user.ID), strings.NewReader(`{"username": "alice", "role": "ghost"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if res, err = app.Test(req, -1); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown role, got %d", res.StatusCode)
+	}
+}
+
+func TestGetUsersRoleFilterSkipsExpiredRoles(t *testing.T) {
+	app, e, db := newRolesTestApp(t)
+	app.Get("/api/admin/users", GetUsers)
+	user := createTestUser(t, e, db, "user")
+
+	// Expired, not swept yet
+	past := time.Now().Add(-time.Minute)
+	if err := db.Create(&models.UserRole{UserID: user.ID, Role: "admin", ExpiresAt: &past}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for role, total := range map[string]int64{"admin": 0, "user": 1} {
+		res, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/admin/users?role="+role, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct{ Data models.UserPage }
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		page := body.Data
+		res.Body.Close()
+
+		if page.Pagination.Total != total {
+			t.Errorf("role %s: expected %d users, got %d", role, total, page.Pagination.Total)
+		}
+		for _, u := range page.Data {
+			if len(u.Roles) != 1 || u.Roles[0].Role != "user" {
+				t.Errorf("expired role listed: %v", u.Roles)
+			}
+		}
+	}
+}
+
+func TestCreateUsersWithoutEmail(t *testing.T) {
+	app, e, _ := newRolesTestApp(t)
+	app.Post("/api/admin/users", CreateUser(e, nil))
+
+	// Email is optional, users without one must not collide
+	for _, username := range []string{"alice", "bob"} {
+		body := `{"username": "` + username + `", "password": "Correct-Horse-42-Battery", "role": "user"}`
+		req := httptest.NewRequest(fiber.MethodPost, "/api/admin/users", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		res, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var data setRolesResponse
+		json.NewDecoder(res.Body).Decode(&data)
+		res.Body.Close()
+		if res.StatusCode != fiber.StatusOK {
+			t.Errorf("creating %s: expected 200, got %d (%s)", username, res.StatusCode, data.Code)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package controllers
+
+import (
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+)
+
+// validateInput checks an input with its `valid` tags, failing with every invalid field if any
+func validateInput(input interface{}) error {
+	if fields := utils.ValidateStruct(input); len(fields) > 0 {
+		return utils.InvalidInput(fields)
+	}
+	return nil
+}
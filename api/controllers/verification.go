@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/config"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"github.com/pcminh0505/gofiber-casbin/infras/mail"
+)
+
+type ResendVerificationInput struct {
+	Email string `valid:"required~Email is required,email~Email is not a valid email address"`
+}
+
+// emailVerificationRequired checks if unverified users are blocked at login (EMAIL_VERIFICATION_REQUIRED)
+func emailVerificationRequired(user models.User) bool {
+	return user.Email != "" && user.EmailVerifiedAt == nil && config.GetEnvBool("EMAIL_VERIFICATION_REQUIRED", false)
+}
+
+// sendEmailVerification emails a signed verification link to a user, in background
+func sendEmailVerification(m mail.Mailer, user models.User) {
+	if user.Email == "" || user.EmailVerifiedAt != nil {
+		return
+	}
+
+	ttl := config.GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	token := utils.SignEmailVerification(user.ID, user.Email, time.Now().Add(ttl))
+
+	link := config.GetEnv("EMAIL_VERIFICATION_URL")
+	if link == "" {
+		link = "http://localhost:8000/api/auth/verify-email"
+	}
+	link += "?token=" + url.QueryEscape(token)
+
+	go func() {
+		if err := m.Send(mail.Message{
+			To:      user.Email,
+			Subject: "Verify your email",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"Open the link below to verify your email, it expires in %s:\n\n%s\n",
+				user.Username, ttl, link),
+		}); err != nil {
+			fmt.Printf("failed to send email verification of userID %d: %v\n", user.ID, err)
+		}
+	}()
+}
+
+// VerifyEmail godoc
+// @Summary     Verify email
+// @Description Mark the email of a user as verified with the signed token of the verification link
+// @Tags        auth
+// @Accept      json
+// @Produce     json
+// @Param       token query    string true "Verification token"
+// @Success     200   {object} models.Response
+// @Failure     400   {object} models.Response
+// @Failure     500   {object} models.Response
+// @Router      /auth/verify-email [get]
+func VerifyEmail(c *fiber.Ctx) error {
+	db := database.GetAdminDB()
+
+	var user models.User
+	userID, err := utils.VerifyEmailVerification(c.Query("token"), func(userID uint) (string, error) {
+		err := db.First(&user, userID).Error
+		return user.Email, err
+	})
+	if err != nil {
+		return utils.NewError(fiber.StatusBadRequest, utils.CodeVerificationInvalid, "Invalid or expired verification link!")
+	}
+
+	if user.EmailVerifiedAt == nil {
+		if err := db.Model(&models.User{}).
+			Where("id = ?", userID).
+			Update("email_verified_at", time.Now()).Error; err != nil {
+			return utils.InternalError("Error when verifying email", err)
+		}
+	}
+
+	return respond(c, "Verify email successfully!", nil)
+}
+
+// ResendVerification godoc
+// @Summary     Resend verification email
+// @Description Email a new verification link. The response is the same whether the email is registered or not
+// @Tags        auth
+// @Param       data body ResendVerificationInput true "Email of the account"
+// @Accept      json
+// @Produce     json
+// @Success     200 {object} models.Response
+// @Failure     400 {object} models.Response
+// @Router      /auth/resend-verification [post]
+func ResendVerification(m mail.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Parse input from request body
+		var data ResendVerificationInput
+		if err := c.BodyParser(&data); err != nil {
+			return utils.NewError(fiber.StatusBadRequest, utils.CodeInvalidInput, "Invalid request params!")
+		}
+
+		if err := validateInput(&data); err != nil {
+			return err
+		}
+
+		var user models.User
+		if res := database.GetAdminDB().
+			Where(&models.User{Email: data.Email}).
+			First(&user); res.RowsAffected > 0 {
+			sendEmailVerification(m, user)
+		}
+
+		return respond(c, "If the email is registered and not verified yet, a verification link has been sent", nil)
+	}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuditEvent records an administrative or authorization event, rows are never updated nor deleted.
+// Each event is chained to the previous one by its hash, so that editing or removing one breaks the chain.
+type AuditEvent struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"index"`
+	ActorID    *uint     `json:"actorId" gorm:"index"`    // Nil for anonymous requests, Eg. failed logins
+	Action     string    `json:"action" gorm:"index"`     // Eg. user.update, authz.denied
+	TargetType string    `json:"targetType" gorm:"index"` // Eg. user, role, policy, route
+	TargetID   string    `json:"targetId" gorm:"index"`
+	Before     JSON      `json:"before,omitempty" gorm:"type:jsonb" swaggertype:"object"` // Changed fields before
+	After      JSON      `json:"after,omitempty" gorm:"type:jsonb" swaggertype:"object"`  // Changed fields after
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	RequestID  string    `json:"requestId" gorm:"index"`
+	PrevHash   string    `json:"prevHash"`          // Hash of the previous event, empty for the first one
+	Hash       string    `json:"hash" gorm:"index"` // SHA-256 of PrevHash and the event
+}
+
+// TableName --> Table for AuditEvent Model
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// AuditCheckpoint is a signature of the audit chain up to an event, with the active ECDSA JWT key.
+// Rewriting the chain up to a checkpoint would require the private key.
+type AuditCheckpoint struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	EventID   uint      `json:"eventId" gorm:"uniqueIndex"`
+	Hash      string    `json:"hash"`      // Hash of the event
+	KeyID     string    `json:"keyId"`     // kid of the signing key
+	PublicKey string    `json:"publicKey"` // PEM of the public key, informative: only keys of the ring or AUDIT_TRUSTED_KEYS are trusted
+	Signature string    `json:"signature"` // Base64 ASN.1 ECDSA signature of "eventID:hash"
+}
+
+// TableName --> Table for AuditCheckpoint Model
+func (AuditCheckpoint) TableName() string {
+	return "audit_checkpoints"
+}
+
+// AuditVerification reports the result of walking the audit chain
+type AuditVerification struct {
+	Valid         bool   `json:"valid"`
+	Checked       int64  `json:"checked"`            // Chained events checked
+	Unchained     int64  `json:"unchained"`          // Events written before chaining was enabled
+	Checkpoints   int64  `json:"checkpoints"`        // Checkpoint signatures checked
+	BrokenEventID *uint  `json:"brokenEventId"`      // First event whose link is broken
+	Reason        string `json:"reason,omitempty"`   // Why the chain is broken
+	LastHash      string `json:"lastHash,omitempty"` // Hash of the last event checked
+	// Keys of verified checkpoints which are no longer JWT signing keys, trusted from AUDIT_TRUSTED_KEYS
+	RetiredKeys []string `json:"retiredKeys,omitempty"`
+}
+
+// ErrAuditAppendOnly is returned when updating or deleting audit events
+var ErrAuditAppendOnly = errors.New("audit events are append-only")
+
+// BeforeUpdate keeps audit events append-only
+func (AuditEvent) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+// BeforeDelete keeps audit events append-only
+func (AuditEvent) BeforeDelete(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+// JSON is a raw JSON value stored as jsonb
+type JSON json.RawMessage
+
+func (j JSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSON) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSON(v)
+	default:
+		return errors.New("unsupported JSON value")
+	}
+	return nil
+}
+
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	*j = append((*j)[:0], data...)
+	return nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginFailure model, counts failed logins of an account ("user:<id>" or "identity:<identity>")
+// or of a client IP ("ip:<ip>") since the last success
+type LoginFailure struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	Key           string     `json:"key" gorm:"uniqueIndex"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil"`
+}
+
+// TableName --> Table for LoginFailure Model
+func (LoginFailure) TableName() string {
+	return "login_failures"
+}
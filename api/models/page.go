@@ -0,0 +1,22 @@
+package models
+
+// Pagination describes a page of a listing. Next page is requested with
+// offset+limit, or with nextCursor which stays stable while rows are inserted.
+type Pagination struct {
+	Total      int64  `json:"total"` // Rows matching the filters
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"nextCursor,omitempty"` // Empty on the last page
+}
+
+// UserPage is a page of users
+type UserPage struct {
+	Data       []User     `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// AuditPage is a page of audit events
+type AuditPage struct {
+	Data       []AuditEvent `json:"data"`
+	Pagination Pagination   `json:"pagination"`
+}
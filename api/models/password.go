@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordHistory model, bcrypt hashes of the passwords a user had, to prevent reuse
+type PasswordHistory struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	Password  string    `json:"-"`
+}
+
+// TableName --> Table for PasswordHistory Model
+func (PasswordHistory) TableName() string {
+	return "password_histories"
+}
@@ -0,0 +1,8 @@
+package models
+
+// Policy model for Casbin `p` rules
+type Policy struct {
+	Subject string `json:"subject"`
+	Object  string `json:"object"`
+	Action  string `json:"action"`
+}
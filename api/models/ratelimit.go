@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// RateLimitBucket model, token bucket of a client shared by every server instance
+type RateLimitBucket struct {
+	Key       string    `json:"key" gorm:"primaryKey"`
+	Tokens    float64   `json:"tokens"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"index"`
+}
+
+// TableName --> Table for RateLimitBucket Model
+func (RateLimitBucket) TableName() string {
+	return "rate_limit_buckets"
+}
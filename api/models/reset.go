@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordResetToken model, single-use token emailed to reset a forgotten password, only its hash is stored
+type PasswordResetToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `json:"userId" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
+
+// TableName --> Table for PasswordResetToken Model
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
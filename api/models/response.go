@@ -1,8 +1,24 @@
 package models
 
-// Response model for Swagger API
+import "github.com/pcminh0505/gofiber-casbin/api/utils"
+
+// Response is the envelope of every JSON answer, successes carry their payload in Data
 type Response struct {
-	Error   bool
-	Message string
-	Data    interface{} `json:",omitempty"`
+	Error   bool               `json:"error"`
+	Code    string             `json:"code,omitempty"` // Machine-readable error code
+	Message string             `json:"message,omitempty"`
+	Fields  []utils.FieldError `json:"fields,omitempty"` // Invalid input fields
+	Data    interface{}        `json:"data,omitempty"`
+}
+
+// Problem is an error answered as RFC 7807 problem details, when the client
+// accepts application/problem+json
+type Problem struct {
+	Type     string             `json:"type"`
+	Title    string             `json:"title"`
+	Status   int                `json:"status"`
+	Detail   string             `json:"detail,omitempty"`
+	Instance string             `json:"instance,omitempty"`
+	Code     string             `json:"code"`
+	Fields   []utils.FieldError `json:"fields,omitempty"`
 }
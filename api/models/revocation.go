@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// TokenRevocation model, revokes a single access token by its JTI,
+// or every access token of a user issued before CreatedAt when JTI is empty
+type TokenRevocation struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	JTI       string    `json:"jti" gorm:"index"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// TableName --> Table for TokenRevocation Model
+func (TokenRevocation) TableName() string {
+	return "token_revocations"
+}
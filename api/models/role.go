@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// Role model
+type Role struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Name        string    `json:"name" gorm:"unique"`
+	Description string    `json:"description"`
+	// Users with this role (directly or inherited) must login with a second factor
+	RequireTwoFactor bool `json:"requireTwoFactor"`
+}
+
+// TableName --> Table for Role Model
+func (Role) TableName() string {
+	return "roles"
+}
+
+// UserRole model, a role assigned to a user, mirrored by a Casbin grouping (g, userID, role)
+type UserRole struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserID    uint      `json:"-" gorm:"uniqueIndex:idx_user_roles_user_role"`
+	Role      string    `json:"role" gorm:"uniqueIndex:idx_user_roles_user_role;index"`
+	// Assignment is removed at this time, never if nil
+	ExpiresAt *time.Time `json:"expiresAt" gorm:"index"`
+}
+
+// TableName --> Table for UserRole Model
+func (UserRole) TableName() string {
+	return "user_roles"
+}
+
+// Expired checks if the assignment is past its expiry
+func (r UserRole) Expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !r.ExpiresAt.After(now)
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken model, only the SHA-256 hash of the opaque token is stored
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `json:"userId" gorm:"index"`
+	FamilyID  string     `json:"familyId" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+// TableName --> Table for RefreshToken Model
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
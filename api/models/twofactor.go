@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// TwoFactor model, TOTP secret of a user. Enrollment is pending until EnabledAt is set
+// by confirming a first code.
+type TwoFactor struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	UserID    uint       `json:"userId" gorm:"uniqueIndex"`
+	Secret    string     `json:"-"`
+	LastStep  int64      `json:"-"` // Last accepted TOTP time step, codes cannot be replayed
+	EnabledAt *time.Time `json:"enabledAt"`
+}
+
+// TableName --> Table for TwoFactor Model
+func (TwoFactor) TableName() string {
+	return "two_factors"
+}
+
+// RecoveryCode model, one-time code replacing a TOTP code, only its hash is stored
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UserID    uint       `json:"userId" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"uniqueIndex"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
+
+// TableName --> Table for RecoveryCode Model
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// LoginChallenge model, a login waiting for its second factor after a correct password
+type LoginChallenge struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	TokenHash string    `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Attempts  int       `json:"attempts"`
+}
+
+// TableName --> Table for LoginChallenge Model
+func (LoginChallenge) TableName() string {
+	return "login_challenges"
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // User model
@@ -13,7 +15,12 @@ type User struct {
 	Password  string    `json:"-"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
-	Role      string    `json:"role"`
+	// Roles assigned to the user, when preloaded
+	Roles []UserRole `json:"roles,omitempty" gorm:"foreignKey:UserID"`
+	// Set when the user opened the link of the verification email
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// Set when the user is deleted, the row is purged after USER_RETENTION
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index" swaggertype:"string"`
 }
 
 // TableName --> Table for User Model
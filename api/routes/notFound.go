@@ -1,6 +1,9 @@
 package routes
 
-import "github.com/gofiber/fiber/v2"
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+)
 
 // NotFoundRoute describes 404 Error route.
 func NotFoundRoute(app *fiber.App) {
@@ -8,11 +11,8 @@ func NotFoundRoute(app *fiber.App) {
 	app.Use(
 		// Anonymous function.
 		func(c *fiber.Ctx) error {
-			// Return HTTP 404 status and JSON response.
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error":   true,
-				"message": "API Endpoint is unavailable",
-			})
+			// Return HTTP 404 status, answered by the error handler.
+			return utils.NewError(fiber.StatusNotFound, utils.CodeNotFound, "API Endpoint is unavailable")
 		},
 	)
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/pcminh0505/gofiber-casbin/api/controllers"
 	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"github.com/pcminh0505/gofiber-casbin/infras/mail"
 	"github.com/pcminh0505/gofiber-casbin/middleware"
 )
 
@@ -14,28 +15,82 @@ func Setup(app *fiber.App) {
 		return c.SendString("Hello, World! Please go to /swagger for API documentation")
 	})
 
-	api := app.Group("/api")                                // Public route
-	admin := api.Group("/admin", middleware.AuthorizeJWT()) // Admin route
+	// Public keys for other services to verify our JWT
+	app.Get("/.well-known/jwks.json", controllers.JWKS)
 
 	// Init Casbin for Role-based Authorization Control (RBAC)
 	enforcer := database.Casbin()
 
+	// Rate limits per route group and role, see RATE_LIMITS
+	limiter := database.NewRateLimitStore()
+
+	// Emails sent to users, see MAILER
+	mailer := mail.NewMailerFromEnv()
+
+	api := app.Group("/api") // Public route
+	admin := api.Group("/admin",
+		middleware.AuthorizeJWT(),
+		middleware.RateLimit("admin", enforcer, limiter),
+	) // Admin route
+
 	// Authentication Routes
-	auth := api.Group("/auth")
+	auth := api.Group("/auth", middleware.RateLimit("auth", enforcer, limiter))
 	auth.Post("/login", controllers.Login)
+	auth.Post("/login/2fa", controllers.LoginTwoFactor)
+	auth.Post("/2fa/enroll", controllers.EnrollTwoFactorChallenge)
 	auth.Post("/logout", controllers.Logout)
-	auth.Post("/register", controllers.CreateUser(enforcer)) // Backup for dev env - Delete when deploy
+	auth.Post("/refresh", controllers.Refresh)
+	auth.Post("/forgot-password", controllers.ForgotPassword(mailer))
+	auth.Post("/reset-password", controllers.ResetPassword)
+	auth.Get("/verify-email", controllers.VerifyEmail)
+	auth.Post("/resend-verification", controllers.ResendVerification(mailer))
+	auth.Post("/register", controllers.CreateUser(enforcer, mailer)) // Backup for dev env - Delete when deploy
 
 	// Users route
 	// Public
-	user := api.Group("/users", middleware.AuthorizeJWT())
+	user := api.Group("/users", middleware.AuthorizeJWT(), middleware.RateLimit("users", enforcer, limiter))
 	user.Put("/:id/password", middleware.AuthorizeCasbin(enforcer), controllers.UpdatePassword) // Update password
+	user.Put("/:id/2fa", middleware.AuthorizeCasbin(enforcer), controllers.BeginTwoFactor)
+	user.Put("/:id/2fa/confirm", middleware.AuthorizeCasbin(enforcer), controllers.ConfirmTwoFactor)
+	user.Put("/:id/2fa/recovery-codes", middleware.AuthorizeCasbin(enforcer), controllers.RegenerateRecoveryCodes)
 
 	// Admin
 	adminUser := admin.Group("/users", middleware.AuthorizeJWT())
 	adminUser.Get("/", middleware.AuthorizeCasbin(enforcer), controllers.GetUsers)
 	adminUser.Get("/:id", middleware.AuthorizeCasbin(enforcer), controllers.GetUser)
-	adminUser.Post("/", middleware.AuthorizeCasbin(enforcer), controllers.CreateUser(enforcer))
-	adminUser.Put("/:id", middleware.AuthorizeCasbin(enforcer), controllers.UpdateUser(enforcer))
+	adminUser.Post("/", middleware.AuthorizeCasbin(enforcer), controllers.CreateUser(enforcer, mailer))
+	adminUser.Put("/:id", middleware.AuthorizeCasbin(enforcer), controllers.UpdateUser(enforcer, mailer))
+	adminUser.Delete("/purge", middleware.AuthorizeCasbin(enforcer), controllers.PurgeUsers)
 	adminUser.Delete("/:id", middleware.AuthorizeCasbin(enforcer), controllers.DeleteUser(enforcer))
+	adminUser.Post("/:id/restore", middleware.AuthorizeCasbin(enforcer), controllers.RestoreUser(enforcer))
+	adminUser.Get("/:id/roles", middleware.AuthorizeCasbin(enforcer), controllers.GetUserRoles)
+	adminUser.Put("/:id/roles", middleware.AuthorizeCasbin(enforcer), controllers.SetUserRoles(enforcer))
+	adminUser.Post("/:id/roles", middleware.AuthorizeCasbin(enforcer), controllers.AddUserRole(enforcer))
+	adminUser.Delete("/:id/sessions", middleware.AuthorizeCasbin(enforcer), controllers.RevokeUserSessions)
+	adminUser.Delete("/:id/lock", middleware.AuthorizeCasbin(enforcer), controllers.UnlockUser)
+	adminUser.Delete("/:id/2fa", middleware.AuthorizeCasbin(enforcer), controllers.ResetTwoFactor)
+
+	// Admin - Policies
+	adminPolicy := admin.Group("/policies")
+	adminPolicy.Get("/", middleware.AuthorizeCasbin(enforcer), controllers.GetPolicies(enforcer))
+	adminPolicy.Post("/", middleware.AuthorizeCasbin(enforcer), controllers.CreatePolicy(enforcer))
+	adminPolicy.Put("/", middleware.AuthorizeCasbin(enforcer), controllers.ReplacePolicies(enforcer))
+	adminPolicy.Delete("/", middleware.AuthorizeCasbin(enforcer), controllers.DeletePolicy(enforcer))
+
+	// Admin - Audit log
+	adminAudit := admin.Group("/audit")
+	adminAudit.Get("/", middleware.AuthorizeCasbin(enforcer), controllers.GetAuditEvents)
+	adminAudit.Get("/export", middleware.AuthorizeCasbin(enforcer), controllers.ExportAuditEvents)
+	adminAudit.Get("/verify", middleware.AuthorizeCasbin(enforcer), controllers.VerifyAuditEvents)
+
+	// Admin - Roles
+	adminRole := admin.Group("/roles")
+	adminRole.Get("/", middleware.AuthorizeCasbin(enforcer), controllers.GetRoles)
+	adminRole.Get("/:name", middleware.AuthorizeCasbin(enforcer), controllers.GetRole(enforcer))
+	adminRole.Post("/", middleware.AuthorizeCasbin(enforcer), controllers.CreateRole)
+	adminRole.Delete("/:name", middleware.AuthorizeCasbin(enforcer), controllers.DeleteRole(enforcer))
+	adminRole.Put("/:name/2fa", middleware.AuthorizeCasbin(enforcer), controllers.SetRoleTwoFactor)
+	adminRole.Get("/:name/permissions", middleware.AuthorizeCasbin(enforcer), controllers.GetRolePermissions(enforcer))
+	adminRole.Post("/:name/parents", middleware.AuthorizeCasbin(enforcer), controllers.AddRoleParent(enforcer))
+	adminRole.Delete("/:name/parents/:parent", middleware.AuthorizeCasbin(enforcer), controllers.RemoveRoleParent(enforcer))
 }
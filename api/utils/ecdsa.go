@@ -1,39 +1,20 @@
 package utils
 
 import (
-	"bufio"
 	"crypto/ecdsa"
-	"fmt"
-	"os"
-
-	"github.com/golang-jwt/jwt/v4"
+	"errors"
 )
 
-func LoadEcdsaPrivateKeyKey() *ecdsa.PrivateKey {
-	privateKeyFile, err := os.Open("private.pem")
+// LoadEcdsaPrivateKeyKey returns the private key currently signing JWT, loaded once by the key provider
+func LoadEcdsaPrivateKeyKey() (*ecdsa.PrivateKey, error) {
+	ring, err := CurrentKeyRing()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	pemfileinfo, _ := privateKeyFile.Stat()
-	var size int64 = pemfileinfo.Size()
-	pembytes := make([]byte, size)
-
-	buffer := bufio.NewReader(privateKeyFile)
-	_, err = buffer.Read(pembytes)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	privateKeyFile.Close()
-
-	// data, _ := pem.Decode([]byte(pembytes))
-
-	privateKeyImported, err := jwt.ParseECPrivateKeyFromPEM(pembytes)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	privateKey, ok := ring.Active.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("active JWT signing key is not an ECDSA key")
 	}
-	return privateKeyImported
+	return privateKey, nil
 }
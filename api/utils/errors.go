@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes answered to clients. Clients branch on codes, messages may change.
+const (
+	CodeInternal     = "internal_error"
+	CodeInvalidInput = "invalid_input"
+	CodeNotFound     = "not_found"
+	CodeRateLimited  = "rate_limited"
+
+	// Authentication
+	CodeInvalidCredentials    = "invalid_credentials"
+	CodeIncorrectPassword     = "incorrect_password"
+	CodeLoginLocked           = "login_locked"
+	CodeEmailNotVerified      = "email_not_verified"
+	CodeTokenInvalid          = "token_invalid"
+	CodeTokenExpired          = "token_expired"
+	CodeTokenNotActive        = "token_not_active"
+	CodeTokenRejected         = "token_rejected" // Issuer or audience is not accepted
+	CodeTokenRevoked          = "token_revoked"
+	CodeRefreshTokenMissing   = "refresh_token_missing"
+	CodeRefreshTokenInvalid   = "refresh_token_invalid"
+	CodeRefreshTokenExpired   = "refresh_token_expired"
+	CodeRefreshTokenReused    = "refresh_token_reused"
+	CodeResetTokenInvalid     = "reset_token_invalid"
+	CodeVerificationInvalid   = "verification_token_invalid"
+	CodeLoginChallengeInvalid = "login_challenge_invalid"
+	CodeTwoFactorCodeInvalid  = "two_factor_code_invalid"
+	CodeTwoFactorEnrollment   = "two_factor_enrollment_required"
+	CodeTwoFactorEnabled      = "two_factor_already_enabled"
+	CodeTwoFactorNotEnabled   = "two_factor_not_enabled"
+	CodeTwoFactorNotPending   = "two_factor_enrollment_not_found"
+	CodeUnauthenticated       = "unauthenticated"
+	CodeForbidden             = "forbidden"
+	CodePasswordPolicy        = "password_policy"
+	CodePasswordUnchanged     = "password_unchanged"
+
+	// Resources
+	CodeUserNotFound            = "user_not_found"
+	CodeUsernameTaken           = "username_taken"
+	CodeEmailTaken              = "email_taken"
+	CodeRoleNotFound            = "role_not_found"
+	CodeRoleExists              = "role_exists"
+	CodeRoleInUse               = "role_in_use"
+	CodeInvalidRoleName         = "invalid_role_name"
+	CodeRoleInheritanceNotFound = "role_inheritance_not_found"
+	CodeRoleInheritanceExists   = "role_inheritance_exists"
+	CodeRoleInheritanceCycle    = "role_inheritance_cycle"
+	CodeInvalidPolicy           = "invalid_policy"
+	CodePolicyNotFound          = "policy_not_found"
+	CodePolicyExists            = "policy_exists"
+	CodePolicyLockout           = "policy_lockout" // Change would remove the caller's access to policies
+)
+
+// AppError is an error answered to the client by the error handler
+type AppError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError // Invalid input fields, if any
+	Err     error        // Cause, logged but never answered
+}
+
+// NewError creates an error answered with a status, a code and a message
+func NewError(status int, code string, message string) *AppError {
+	return &AppError{Status: status, Code: code, Message: message}
+}
+
+// InternalError creates a 500 error caused by err
+func InternalError(message string, err error) *AppError {
+	return &AppError{Status: fiber.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// InvalidInput creates a 400 error listing the invalid fields of an input
+func InvalidInput(fields []FieldError) *AppError {
+	return &AppError{Status: fiber.StatusBadRequest, Code: CodeInvalidInput, Message: "Invalid request params!", Fields: fields}
+}
+
+func (e *AppError) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// WithFields attaches the invalid fields of an input
+func (e *AppError) WithFields(fields []FieldError) *AppError {
+	e.Fields = fields
+	return e
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+// TokenExtractor reads a raw JWT from the request, returns empty string if missing
+type TokenExtractor func(c *fiber.Ctx) string
+
+// Default chain when JWT_TOKEN_LOOKUP is not set
+const defaultTokenLookup = "header:Authorization,cookie:jwt"
+
+var tokenExtractors = NewTokenExtractors(config.GetEnv("JWT_TOKEN_LOOKUP"))
+
+// FromAuthHeader reads a token from a header with Bearer scheme
+func FromAuthHeader(header string) TokenExtractor {
+	return func(c *fiber.Ctx) string {
+		auth := c.Get(header)
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			return strings.TrimSpace(auth[7:])
+		}
+		return ""
+	}
+}
+
+// FromCookie reads a token from a cookie
+func FromCookie(name string) TokenExtractor {
+	return func(c *fiber.Ctx) string {
+		return c.Cookies(name)
+	}
+}
+
+// FromQuery reads a token from a query param, for clients which cannot set headers (Eg. websocket)
+func FromQuery(name string) TokenExtractor {
+	return func(c *fiber.Ctx) string {
+		return c.Query(name)
+	}
+}
+
+// NewTokenExtractors parses a lookup chain like "header:Authorization,cookie:jwt,query:token"
+func NewTokenExtractors(lookup string) []TokenExtractor {
+	if IsEmpty(lookup) {
+		lookup = defaultTokenLookup
+	}
+
+	var extractors []TokenExtractor
+	for _, source := range strings.Split(lookup, ",") {
+		parts := strings.SplitN(strings.TrimSpace(source), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "header":
+			extractors = append(extractors, FromAuthHeader(parts[1]))
+		case "cookie":
+			extractors = append(extractors, FromCookie(parts[1]))
+		case "query":
+			extractors = append(extractors, FromQuery(parts[1]))
+		}
+	}
+	return extractors
+}
+
+// ExtractToken returns the first token found by the configured extractor chain
+func ExtractToken(c *fiber.Ctx) string {
+	for _, extract := range tokenExtractors {
+		if token := extract(c); token != "" {
+			return token
+		}
+	}
+	return ""
+}
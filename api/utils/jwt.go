@@ -1,24 +1,172 @@
 package utils
 
 import (
+	"errors"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/pcminh0505/gofiber-casbin/config"
 )
 
-var privateKey = LoadEcdsaPrivateKeyKey()
+// AccessTokenTTL is the lifetime of JWT access tokens
+const AccessTokenTTL = time.Hour * 1
 
-// GenerateJWT create a new RegisteredClaim and sign with private key
-func GenerateJWT(issuer string, userID uint) (string, error) {
-	// Create JWT token
-	claims := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
-		Issuer:    issuer,
-		Subject:   strconv.Itoa(int(userID)),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 1)), // 1 hour
+// defaultIssuer is the iss claim when JWT_ISSUER is not set
+const defaultIssuer = "gofiber-casbin"
+
+// JWTSettings are the claims settings of issued and verified tokens, read once at startup
+type JWTSettings struct {
+	Issuer     string        // iss of issued tokens, also required when verifying
+	Audience   []string      // Accepted audiences, tokens are not audience restricted if empty
+	Leeway     time.Duration // Allowed clock skew
+	RoleClaims bool          // Roles are embedded in access tokens
+}
+
+var (
+	jwtSettingsMu sync.RWMutex
+	jwtSettings   = JWTSettings{Issuer: defaultIssuer, Leeway: 30 * time.Second}
+)
+
+// JWTSettingsFromEnv reads JWT_ISSUER, JWT_AUDIENCE (comma separated), JWT_LEEWAY and JWT_ROLE_CLAIMS
+func JWTSettingsFromEnv() JWTSettings {
+	settings := JWTSettings{
+		Issuer:     config.GetEnv("JWT_ISSUER"),
+		Leeway:     config.GetEnvDuration("JWT_LEEWAY", 30*time.Second),
+		RoleClaims: config.GetEnvBool("JWT_ROLE_CLAIMS", false),
+	}
+	if settings.Issuer == "" {
+		settings.Issuer = defaultIssuer
+	}
+	for _, aud := range strings.Split(config.GetEnv("JWT_AUDIENCE"), ",") {
+		if aud = strings.TrimSpace(aud); aud != "" {
+			settings.Audience = append(settings.Audience, aud)
+		}
+	}
+	return settings
+}
+
+// SetJWTSettings sets the settings used by GenerateJWT and when validating claims
+func SetJWTSettings(settings JWTSettings) {
+	jwtSettingsMu.Lock()
+	defer jwtSettingsMu.Unlock()
+	jwtSettings = settings
+}
+
+// CurrentJWTSettings returns the settings set at startup, defaults if never set
+func CurrentJWTSettings() JWTSettings {
+	jwtSettingsMu.RLock()
+	defer jwtSettingsMu.RUnlock()
+	return jwtSettings
+}
+
+// Claims are the claims of access tokens. Roles are only embedded when JWT_ROLE_CLAIMS is enabled,
+// they are a snapshot taken at login and must not replace casbin for fine-grained authorization.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Valid validates the claims with the current settings
+func (c *Claims) Valid() error {
+	return c.Validate(CurrentJWTSettings())
+}
+
+// Validate validates time based claims allowing the leeway of clock skew, then issuer and audience
+func (c *Claims) Validate(settings JWTSettings) error {
+	now := time.Now()
+	leeway := settings.Leeway
+	vErr := new(jwt.ValidationError)
+
+	if !c.VerifyExpiresAt(now.Add(-leeway), true) {
+		vErr.Inner = errors.New("token is expired")
+		vErr.Errors |= jwt.ValidationErrorExpired
+	}
+
+	if !c.VerifyIssuedAt(now.Add(leeway), false) {
+		vErr.Inner = errors.New("token used before issued")
+		vErr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+
+	if !c.VerifyNotBefore(now.Add(leeway), false) {
+		vErr.Inner = errors.New("token is not valid yet")
+		vErr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+
+	if !c.VerifyIssuer(settings.Issuer, true) {
+		vErr.Inner = errors.New("token has invalid issuer")
+		vErr.Errors |= jwt.ValidationErrorIssuer
+	}
+
+	// Token must be intended for at least one of the accepted audiences
+	if audience := settings.Audience; len(audience) > 0 {
+		accepted := false
+		for _, aud := range audience {
+			accepted = accepted || c.VerifyAudience(aud, true)
+		}
+		if !accepted {
+			vErr.Inner = errors.New("token has invalid audience")
+			vErr.Errors |= jwt.ValidationErrorAudience
+		}
+	}
+
+	if vErr.Errors == 0 {
+		return nil
+	}
+	return vErr
+}
+
+// RoleClaimsEnabled checks if roles are embedded in access tokens (JWT_ROLE_CLAIMS)
+func RoleClaimsEnabled() bool {
+	return CurrentJWTSettings().RoleClaims
+}
+
+// GenerateJWT create new Claims for a user and sign with private key
+func GenerateJWT(userID uint, roles []string) (string, error) {
+	// Unique token ID used for revocation
+	jti, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	ring, err := CurrentKeyRing()
+	if err != nil {
+		return "", err
+	}
+
+	// Create JWT token with the configured algorithm
+	settings := CurrentJWTSettings()
+	now := time.Now()
+	claims := jwt.NewWithClaims(ring.SigningMethod(), &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    settings.Issuer,
+			Subject:   strconv.Itoa(int(userID)),
+			Audience:  settings.Audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)), // 1 hour
+		},
+		Roles: roles,
 	})
 
-	// Sign with private key
-	t, err := claims.SignedString(privateKey)
+	// Sign with active private key, kid tells verifiers which key to use
+	claims.Header["kid"] = ring.Active.ID
+	t, err := claims.SignedString(ring.Active.PrivateKey)
 	return t, err
 }
+
+// ParseJWT verifies a token with the public key and returns its claims
+func ParseJWT(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, KeyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return token.Claims.(*Claims), nil
+}
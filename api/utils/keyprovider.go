@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+// KeyProvider supplies the key ring used to sign and verify JWT
+type KeyProvider interface {
+	KeyRing() (*KeyRing, error)
+}
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProvider   KeyProvider
+)
+
+// SetKeyProvider sets the key provider used by GenerateJWT and ParseJWT
+func SetKeyProvider(provider KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = provider
+}
+
+// CurrentKeyRing returns the key ring of the current key provider
+func CurrentKeyRing() (*KeyRing, error) {
+	keyProviderMu.RLock()
+	provider := keyProvider
+	keyProviderMu.RUnlock()
+
+	if provider == nil {
+		return nil, errors.New("JWT key provider is not initialized")
+	}
+	return provider.KeyRing()
+}
+
+// KeyFunc selects the verification key of a token in the current key ring
+func KeyFunc(token *jwt.Token) (interface{}, error) {
+	ring, err := CurrentKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	return ring.KeyFunc(token)
+}
+
+// StaticKeyProvider serves keys parsed once from PEM content (Eg. env var or inline config)
+type StaticKeyProvider struct {
+	ring *KeyRing
+}
+
+// NewPEMKeyProvider parses a PEM bundle, the first key signs unless active (kid) is set
+func NewPEMKeyProvider(alg string, pembytes []byte, active string) (*StaticKeyProvider, error) {
+	privateKeys, err := ParsePrivateKeys(alg, pembytes)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(privateKeys))
+	for _, privateKey := range privateKeys {
+		keys = append(keys, &SigningKey{PrivateKey: privateKey})
+	}
+
+	ring, err := NewKeyRing(alg, keys, active)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{ring: ring}, nil
+}
+
+func (p *StaticKeyProvider) KeyRing() (*KeyRing, error) {
+	return p.ring, nil
+}
+
+// FileKeyProvider serves keys loaded from PEM files, reloaded when the files change
+type FileKeyProvider struct {
+	alg    string
+	dir    string
+	files  []string
+	active string
+
+	mu      sync.RWMutex
+	ring    *KeyRing
+	modTime map[string]time.Time
+	retired map[string]retiredKey
+	stop    chan struct{}
+}
+
+// retiredKey is a key removed from disk, still accepted until the tokens it signed have expired
+type retiredKey struct {
+	key   *SigningKey
+	until time.Time
+}
+
+// NewFileKeyProvider loads every PEM of dir (newest name first), or the given files in order
+func NewFileKeyProvider(alg string, dir string, files []string, active string) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{alg: alg, dir: dir, files: files, active: active, retired: make(map[string]retiredKey)}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *FileKeyProvider) KeyRing() (*KeyRing, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ring, nil
+}
+
+// paths lists the key files in order of preference
+func (p *FileKeyProvider) paths() ([]string, error) {
+	if p.dir == "" {
+		return p.files, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(p.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files, nil
+}
+
+// Reload reads the key files again, the current ring is kept if any of them is invalid
+func (p *FileKeyProvider) Reload() error {
+	files, err := p.paths()
+	if err != nil {
+		return err
+	}
+
+	var keys []*SigningKey
+	modTime := make(map[string]time.Time)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTime[file] = info.ModTime()
+
+		privateKeys, err := LoadPrivateKeyFile(p.alg, file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, privateKey := range privateKeys {
+			keys = append(keys, &SigningKey{Name: filepath.Base(file), PrivateKey: privateKey})
+		}
+	}
+
+	if len(keys) == 0 {
+		return errors.New("no JWT signing key found")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Keep verifying with keys removed from disk until their tokens expire
+	loaded := make(map[string]bool)
+	for _, key := range keys {
+		key.ID = Thumbprint(key.PrivateKey)
+		loaded[key.ID] = true
+	}
+	if p.ring != nil {
+		for id, key := range p.ring.Keys {
+			if _, ok := p.retired[id]; !ok && !loaded[id] {
+				p.retired[id] = retiredKey{key: key, until: time.Now().Add(AccessTokenTTL)}
+			}
+		}
+	}
+	for id, retired := range p.retired {
+		if loaded[id] || time.Now().After(retired.until) {
+			delete(p.retired, id)
+			continue
+		}
+		keys = append(keys, &SigningKey{ID: id, PrivateKey: retired.key.PrivateKey})
+	}
+
+	ring, err := NewKeyRing(p.alg, keys, p.active)
+	if err != nil {
+		return err
+	}
+
+	p.ring = ring
+	p.modTime = modTime
+	return nil
+}
+
+// changed checks if a key file was added, removed or modified since last load
+func (p *FileKeyProvider) changed() bool {
+	files, err := p.paths()
+	if err != nil {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(files) != len(p.modTime) {
+		return true
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return true
+		}
+		if last, ok := p.modTime[file]; !ok || !info.ModTime().Equal(last) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch polls the key files and reloads them on change until Close is called
+func (p *FileKeyProvider) Watch(interval time.Duration) {
+	stop := make(chan struct{})
+	p.stop = stop
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.RLock()
+				pending := len(p.retired) > 0
+				p.mu.RUnlock()
+				if !pending && !p.changed() {
+					continue
+				}
+				if err := p.Reload(); err != nil {
+					fmt.Printf("failed to reload JWT signing keys, keeping current keys: %v\n", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching the key files
+func (p *FileKeyProvider) Close() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// NewKeyProviderFromEnv creates the key provider configured by env, for the JWT_ALGORITHM
+// (ES256 default, RS256, EdDSA or HS256) keys of:
+//   - JWT_PRIVATE_KEY: PEM content, for keys from env var or inline config (literal \n are allowed)
+//   - JWT_KEYS_DIR: directory of PEM files, newest name first
+//   - JWT_KEY_FILES: comma separated list of PEM files, first one first
+//   - private.pem otherwise
+//
+// JWT_ACTIVE_KEY selects the signing key (file name or kid). Files are reloaded
+// every JWT_KEY_RELOAD_INTERVAL (default 30s, 0 to disable).
+func NewKeyProviderFromEnv() (KeyProvider, error) {
+	active := config.GetEnv("JWT_ACTIVE_KEY")
+	alg := config.GetEnv("JWT_ALGORITHM")
+	if alg == "" {
+		alg = AlgorithmES256
+	}
+
+	if inline := config.GetEnv("JWT_PRIVATE_KEY"); inline != "" {
+		return NewPEMKeyProvider(alg, []byte(strings.ReplaceAll(inline, `\n`, "\n")), active)
+	}
+
+	var files []string
+	dir := config.GetEnv("JWT_KEYS_DIR")
+	if list := config.GetEnv("JWT_KEY_FILES"); dir == "" && list != "" {
+		for _, file := range strings.Split(list, ",") {
+			files = append(files, strings.TrimSpace(file))
+		}
+	} else if dir == "" {
+		files = []string{"private.pem"}
+	}
+
+	provider, err := NewFileKeyProvider(alg, dir, files, active)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval := config.GetEnvDuration("JWT_KEY_RELOAD_INTERVAL", 30*time.Second); interval > 0 {
+		provider.Watch(interval)
+	}
+	return provider, nil
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgorithmES256 = "ES256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmHS256 = "HS256"
+)
+
+// SigningKey is a private key identified by its kid.
+// PrivateKey is a *ecdsa.PrivateKey, *rsa.PrivateKey, ed25519.PrivateKey or a []byte HMAC secret.
+type SigningKey struct {
+	ID         string
+	Name       string // File name the key was loaded from, if any
+	PrivateKey interface{}
+}
+
+// KeyRing holds the key signing new tokens, and every key still accepted when verifying.
+// Retired keys should stay in the ring until the tokens they signed have expired (AccessTokenTTL).
+type KeyRing struct {
+	Algorithm string
+	Active    *SigningKey
+	Keys      map[string]*SigningKey
+}
+
+// JWK is the public part of a signing key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// SigningMethod returns the jwt signing method of an algorithm
+func SigningMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case AlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256, nil
+	default:
+		return nil, errors.New("unsupported JWT algorithm: " + alg)
+	}
+}
+
+// NewKeyRing creates a ring from keys of one algorithm in order of preference. The active key
+// is the one whose kid or name is active, or the first key if active is empty.
+func NewKeyRing(alg string, keys []*SigningKey, active string) (*KeyRing, error) {
+	if _, err := SigningMethod(alg); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no JWT signing key found")
+	}
+
+	ring := &KeyRing{Algorithm: alg, Keys: make(map[string]*SigningKey)}
+	for _, key := range keys {
+		if !isKeyOf(alg, key.PrivateKey) {
+			return nil, errors.New("key " + key.Name + " cannot be used with " + alg)
+		}
+		if key.ID == "" {
+			key.ID = Thumbprint(key.PrivateKey)
+		}
+		ring.Keys[key.ID] = key
+
+		if active == "" && ring.Active == nil {
+			ring.Active = key
+		} else if active != "" && (active == key.ID || active == key.Name) {
+			ring.Active = key
+		}
+	}
+
+	if ring.Active == nil {
+		return nil, errors.New("active JWT signing key not found: " + active)
+	}
+	return ring, nil
+}
+
+// isKeyOf checks if a private key can sign with an algorithm
+func isKeyOf(alg string, privateKey interface{}) bool {
+	switch k := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return alg == AlgorithmES256 && k.Curve.Params().BitSize == 256
+	case *rsa.PrivateKey:
+		return alg == AlgorithmRS256 && k.N.BitLen() >= 2048
+	case ed25519.PrivateKey:
+		return alg == AlgorithmEdDSA
+	case []byte:
+		return alg == AlgorithmHS256 && len(k) >= 32
+	}
+	return false
+}
+
+// verifyKey returns the key verifying signatures of a private key
+func verifyKey(privateKey interface{}) interface{} {
+	switch k := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	// HMAC secret signs and verifies
+	return privateKey
+}
+
+// SigningMethod returns the jwt signing method of the ring
+func (r *KeyRing) SigningMethod() jwt.SigningMethod {
+	method, _ := SigningMethod(r.Algorithm)
+	return method
+}
+
+// KeyFunc selects the verification key of a token by its kid header, rejecting any other algorithm
+// than the configured one. Tokens without kid were issued before key rotation and are verified
+// with the active key.
+func (r *KeyRing) KeyFunc(token *jwt.Token) (interface{}, error) {
+	if token.Method == nil || token.Method.Alg() != r.Algorithm {
+		return nil, errors.New("unexpected signing algorithm")
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return verifyKey(r.Active.PrivateKey), nil
+	}
+
+	key, ok := r.Keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key: " + kid)
+	}
+	return verifyKey(key.PrivateKey), nil
+}
+
+// JWKS returns the public keys of the ring as a JSON Web Key Set, HMAC secrets are never published
+func (r *KeyRing) JWKS() map[string][]JWK {
+	keys := make([]JWK, 0, len(r.Keys))
+	for _, key := range r.Keys {
+		if jwk, ok := toJWK(key.PrivateKey); ok {
+			jwk.Kid = key.ID
+			jwk.Use = "sig"
+			jwk.Alg = r.Algorithm
+			keys = append(keys, jwk)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+
+	return map[string][]JWK{"keys": keys}
+}
+
+// toJWK returns the public JWK of a private or public key
+func toJWK(key interface{}) (JWK, bool) {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return toJWK(&k.PublicKey)
+	case *rsa.PrivateKey:
+		return toJWK(&k.PublicKey)
+	case ed25519.PrivateKey:
+		return toJWK(k.Public())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   encode(k.X.FillBytes(make([]byte, size))),
+			Y:   encode(k.Y.FillBytes(make([]byte, size))),
+		}, true
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encode(k.N.Bytes()),
+			E:   encode(big.NewInt(int64(k.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encode(k),
+		}, true
+	}
+	return JWK{}, false
+}
+
+// Thumbprint computes the JWK thumbprint (RFC 7638) of a key, used as kid. Private and public keys
+// of a pair have the same thumbprint.
+func Thumbprint(privateKey interface{}) string {
+	var members interface{}
+	if jwk, ok := toJWK(privateKey); ok {
+		// Required members only, in lexicographic order
+		switch jwk.Kty {
+		case "EC":
+			members = struct {
+				Crv string `json:"crv"`
+				Kty string `json:"kty"`
+				X   string `json:"x"`
+				Y   string `json:"y"`
+			}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+		case "RSA":
+			members = struct {
+				E   string `json:"e"`
+				Kty string `json:"kty"`
+				N   string `json:"n"`
+			}{jwk.E, jwk.Kty, jwk.N}
+		case "OKP":
+			members = struct {
+				Crv string `json:"crv"`
+				Kty string `json:"kty"`
+				X   string `json:"x"`
+			}{jwk.Crv, jwk.Kty, jwk.X}
+		}
+	} else if secret, ok := privateKey.([]byte); ok {
+		members = struct {
+			K   string `json:"k"`
+			Kty string `json:"kty"`
+		}{base64.RawURLEncoding.EncodeToString(secret), "oct"}
+	}
+
+	canonical, _ := json.Marshal(members)
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/pem"
+	"errors"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// hmacSecretBlock is the PEM block type of HS256 secrets
+const hmacSecretBlock = "HMAC SECRET"
+
+// LoadPrivateKeyFile reads every private key of a PEM file for an algorithm
+func LoadPrivateKeyFile(alg string, path string) ([]interface{}, error) {
+	pembytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKeys(alg, pembytes)
+}
+
+// ParsePrivateKeys parses every private key of a PEM bundle for an algorithm.
+// HS256 secrets are "HMAC SECRET" blocks, or the raw content if it is not PEM.
+func ParsePrivateKeys(alg string, pembytes []byte) ([]interface{}, error) {
+	if _, err := SigningMethod(alg); err != nil {
+		return nil, err
+	}
+
+	var keys []interface{}
+	rest := pembytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		privateKey, err := parsePrivateKey(alg, block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, privateKey)
+	}
+
+	if len(keys) == 0 && alg == AlgorithmHS256 {
+		if secret := bytes.TrimSpace(pembytes); len(secret) > 0 {
+			keys = append(keys, secret)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no private key found in PEM")
+	}
+	return keys, nil
+}
+
+func parsePrivateKey(alg string, block *pem.Block) (interface{}, error) {
+	encoded := pem.EncodeToMemory(block)
+
+	switch alg {
+	case AlgorithmES256:
+		return jwt.ParseECPrivateKeyFromPEM(encoded)
+	case AlgorithmRS256:
+		return jwt.ParseRSAPrivateKeyFromPEM(encoded)
+	case AlgorithmEdDSA:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(encoded)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return nil, jwt.ErrNotEdPrivateKey
+		}
+		return edKey, nil
+	default:
+		if block.Type != hmacSecretBlock {
+			return nil, errors.New("expected " + hmacSecretBlock + " block, found " + block.Type)
+		}
+		return block.Bytes, nil
+	}
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+// bcryptMaxBytes is the length bcrypt hashes, longer passwords are rejected rather than silently truncated
+const bcryptMaxBytes = 72
+
+//go:embed data/common-passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = parseDenylist(commonPasswordsFile)
+
+// PasswordPolicy are the rules every new password must follow
+type PasswordPolicy struct {
+	MinLength     int // In characters
+	MaxLength     int // In bytes, at most bcryptMaxBytes
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	HistorySize   int // Number of previous passwords which cannot be reused
+	CheckDenylist bool
+	CheckIdentity bool // Reject passwords containing the username or email
+}
+
+// PasswordViolation is a rule broken by a password
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyFromEnv returns the policy configured by PASSWORD_* env
+func PasswordPolicyFromEnv() PasswordPolicy {
+	policy := PasswordPolicy{
+		MinLength:     config.GetEnvInt("PASSWORD_MIN_LENGTH", 10),
+		MaxLength:     config.GetEnvInt("PASSWORD_MAX_LENGTH", bcryptMaxBytes),
+		RequireUpper:  config.GetEnvBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:  config.GetEnvBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:  config.GetEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: config.GetEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		HistorySize:   config.GetEnvInt("PASSWORD_HISTORY_SIZE", 5),
+		CheckDenylist: config.GetEnvBool("PASSWORD_DENYLIST", true),
+		CheckIdentity: true,
+	}
+
+	if policy.MaxLength <= 0 || policy.MaxLength > bcryptMaxBytes {
+		policy.MaxLength = bcryptMaxBytes
+	}
+	if policy.MinLength < 1 {
+		policy.MinLength = 1
+	}
+	return policy
+}
+
+// Validate returns every rule broken by a password, identities are the username and email of its user.
+// Reuse of previous passwords is checked against the password history by the caller.
+func (p PasswordPolicy) Validate(password string, identities ...string) []PasswordViolation {
+	var violations []PasswordViolation
+	add := func(code string, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if IsEmpty(password) {
+		add("required", "Password is required")
+		return violations
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		add("min_length", "Password must have at least %d characters", p.MinLength)
+	}
+	if len(password) > p.MaxLength {
+		add("max_length", "Password must have at most %d bytes", p.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		add("upper", "Password must have an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		add("lower", "Password must have a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		add("digit", "Password must have a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add("symbol", "Password must have a symbol")
+	}
+
+	if p.CheckDenylist && commonPasswords[strings.ToLower(password)] {
+		add("common", "Password is too common")
+	}
+
+	if p.CheckIdentity {
+		for _, identity := range identities {
+			// Email local part is what users tend to reuse
+			identity, _, _ = strings.Cut(strings.ToLower(identity), "@")
+			if len(identity) >= 3 && strings.Contains(strings.ToLower(password), identity) {
+				add("identity", "Password must not contain the username or email")
+				break
+			}
+		}
+	}
+
+	return violations
+}
+
+// parseDenylist reads one password per line, ignoring blank lines and # comments
+func parseDenylist(content string) map[string]bool {
+	denylist := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			denylist[strings.ToLower(line)] = true
+		}
+	}
+	return denylist
+}
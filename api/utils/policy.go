@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"regexp"
+)
+
+// HTTP methods a policy action can be matched against
+var policyMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// A policy object is an absolute path made of plain segments or `:param` segments,
+// optionally ending with a `*` wildcard. This keeps it compatible with both
+// keyMatch and keyMatch2 used in config/restful_rbac_model.conf.
+var policyObjectRegex = regexp.MustCompile(`^(/([A-Za-z0-9_.~-]+|:[A-Za-z_][A-Za-z0-9_]*))*(/\*|\*|/)?$`)
+
+// IsValidPolicyObject checks if an object pattern can be used with keyMatch/keyMatch2
+func IsValidPolicyObject(obj string) bool {
+	if !policyObjectRegex.MatchString(obj) {
+		return false
+	}
+	return len(obj) > 0 && obj[0] == '/'
+}
+
+// IsValidPolicyAction checks if an action is a valid regex matching at least one HTTP method
+func IsValidPolicyAction(act string) bool {
+	if IsEmpty(act) {
+		return false
+	}
+
+	re, err := regexp.Compile(act)
+	if err != nil {
+		return false
+	}
+
+	for _, method := range policyMethods {
+		if re.MatchString(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// A role name must not look like a user ID since both are Casbin subjects
+var roleNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// IsValidRoleName checks if a string can be used as a role name
+func IsValidRoleName(name string) bool {
+	return roleNameRegex.MatchString(name)
+}
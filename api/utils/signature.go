@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+var (
+	emailSecretOnce sync.Once
+	emailSecret     []byte
+)
+
+// emailVerificationSecret returns EMAIL_VERIFICATION_SECRET, or a random secret if not set
+// (links are then only valid on this instance until it restarts)
+func emailVerificationSecret() []byte {
+	emailSecretOnce.Do(func() {
+		if secret := config.GetEnv("EMAIL_VERIFICATION_SECRET"); secret != "" {
+			emailSecret = []byte(secret)
+			return
+		}
+
+		fmt.Println("EMAIL_VERIFICATION_SECRET is not set, using a random secret")
+		emailSecret = make([]byte, 32)
+		rand.Read(emailSecret)
+	})
+	return emailSecret
+}
+
+func signEmail(userID uint, email string, expires int64) []byte {
+	mac := hmac.New(sha256.New, emailVerificationSecret())
+	fmt.Fprintf(mac, "email-verification|%d|%s|%d", userID, strings.ToLower(email), expires)
+	return mac.Sum(nil)
+}
+
+// SignEmailVerification creates the token of a verification link: userID.expires.signature.
+// The signature covers the email, so that links sent to a previous email stop working.
+func SignEmailVerification(userID uint, email string, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	signature := base64.RawURLEncoding.EncodeToString(signEmail(userID, email, expires))
+	return fmt.Sprintf("%d.%d.%s", userID, expires, signature)
+}
+
+// VerifyEmailVerification checks a verification token against the current email of its user
+func VerifyEmailVerification(token string, emailOf func(userID uint) (string, error)) (uint, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errors.New("malformed token")
+	}
+
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, errors.New("malformed token")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, errors.New("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, errors.New("malformed token")
+	}
+
+	if time.Now().Unix() > expires {
+		return 0, errors.New("token is expired")
+	}
+
+	email, err := emailOf(uint(id))
+	if err != nil {
+		return 0, err
+	}
+	if !hmac.Equal(signature, signEmail(uint(id), email, expires)) {
+		return 0, errors.New("invalid signature")
+	}
+	return uint(id), nil
+}
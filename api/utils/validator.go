@@ -1,10 +1,63 @@
 package utils
 
 import (
+	"errors"
+
 	valid "github.com/asaskevich/govalidator"
 )
 
+// FieldError is a validation failure of one input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
 // IsEmpty checks if a string is empty
 func IsEmpty(str string) bool {
 	return valid.HasWhitespaceOnly(str) || str == ""
 }
+
+// IsValidEmail checks if a string is an email address
+func IsValidEmail(str string) bool {
+	return valid.IsEmail(str)
+}
+
+// RegisterValidator adds a rule usable in `valid` tags, Eg. role checking that a role is defined.
+// Rules must be registered at init, before any validation.
+func RegisterValidator(name string, fn func(str string) bool) {
+	valid.TagMap[name] = valid.Validator(fn)
+}
+
+// ValidateStruct validates an input with its `valid` tags (See: github.com/asaskevich/govalidator)
+// and returns every failure of every field. Custom messages follow the rule: `valid:"email~Invalid email"`.
+func ValidateStruct(input interface{}) []FieldError {
+	ok, err := valid.ValidateStruct(input)
+	if ok || err == nil {
+		return nil
+	}
+
+	var fields []FieldError
+	var collect func(err error)
+	collect = func(err error) {
+		var errs valid.Errors
+		var fieldErr valid.Error
+		switch {
+		case errors.As(err, &errs):
+			for _, e := range errs {
+				collect(e)
+			}
+		case errors.As(err, &fieldErr):
+			message := fieldErr.Err.Error()
+			if !fieldErr.CustomErrorMessageExists {
+				message = fieldErr.Name + " " + message
+			}
+			fields = append(fields, FieldError{Field: fieldErr.Name, Rule: fieldErr.Validator, Message: message})
+		default:
+			fields = append(fields, FieldError{Message: err.Error()})
+		}
+	}
+	collect(err)
+
+	return fields
+}
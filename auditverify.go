@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+)
+
+// auditVerify walks the audit chain and prints the report: go run . audit-verify
+// Exits with 1 if the chain is broken.
+func auditVerify() int {
+	// Keys verify the checkpoint signatures
+	keyProvider, err := utils.NewKeyProviderFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load JWT signing keys:", err)
+		return 1
+	}
+	utils.SetKeyProvider(keyProvider)
+
+	auditKeys, err := database.AuditTrustedKeysFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load audit trusted keys:", err)
+		return 1
+	}
+	database.SetAuditTrustedKeys(auditKeys)
+
+	database.Connect()
+
+	report, err := database.VerifyAuditChain()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
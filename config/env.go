@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -22,3 +24,30 @@ func GetEnv(key string) string {
 
 	return os.Getenv(key)
 }
+
+// GetEnvDuration func to get env value as a duration (Eg. 30m, 720h), fallback if missing or invalid
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(GetEnv(key))
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+// GetEnvBool func to get env value as a bool (Eg. true, 1, false), fallback if missing or invalid
+func GetEnvBool(key string, fallback bool) bool {
+	b, err := strconv.ParseBool(GetEnv(key))
+	if err != nil {
+		return fallback
+	}
+	return b
+}
+
+// GetEnvInt func to get env value as an int, fallback if missing or invalid
+func GetEnvInt(key string, fallback int) int {
+	i, err := strconv.Atoi(GetEnv(key))
+	if err != nil {
+		return fallback
+	}
+	return i
+}
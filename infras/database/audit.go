@@ -0,0 +1,409 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// auditChainLock is the advisory lock serializing audit writers, so that every event links to the one before it
+const auditChainLock = 0x61756469 // "audi"
+
+// Audit events waiting for the writer, and how many are written per transaction
+const (
+	auditQueueSize = 4096
+	auditBatchSize = 100
+)
+
+var (
+	auditQueue      = make(chan *models.AuditEvent, auditQueueSize)
+	auditWriterOnce sync.Once
+)
+
+// QueueAuditEvent hands an event to the single audit writer, so that requests never wait for the
+// chain lock. Events are dropped and logged if the queue is full, Eg. under a flood of failed logins.
+func QueueAuditEvent(event *models.AuditEvent) {
+	auditWriterOnce.Do(func() { go writeAuditQueue() })
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	select {
+	case auditQueue <- event:
+	default:
+		fmt.Printf("audit queue is full, dropping event %s of %s %s\n", event.Action, event.TargetType, event.TargetID)
+	}
+}
+
+// writeAuditQueue writes the queued events in batches, one by one if a batch fails
+func writeAuditQueue() {
+	for event := range auditQueue {
+		batch := append(make([]*models.AuditEvent, 0, auditBatchSize), event)
+		for len(batch) < auditBatchSize && len(auditQueue) > 0 {
+			batch = append(batch, <-auditQueue)
+		}
+
+		if err := writeAuditEvents(batch); err == nil {
+			continue
+		}
+		for _, event := range batch {
+			if err := WriteAuditEvent(event); err != nil {
+				fmt.Printf("failed to write audit event %s of %s %s: %v\n", event.Action, event.TargetType, event.TargetID, err)
+			}
+		}
+	}
+}
+
+// WriteAuditEvent appends an event to the audit log, chained to the last event by its hash
+func WriteAuditEvent(event *models.AuditEvent) error {
+	return writeAuditEvents([]*models.AuditEvent{event})
+}
+
+// writeAuditEvents appends events in order in one transaction, each chained to the one before it
+func writeAuditEvents(events []*models.AuditEvent) error {
+	return adminDB.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLock).Error; err != nil {
+				return err
+			}
+		}
+
+		var last models.AuditEvent
+		if err := tx.Select("hash").Order("id desc").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+
+		prevHash := last.Hash
+		for _, event := range events {
+			if event.CreatedAt.IsZero() {
+				event.CreatedAt = time.Now()
+			}
+			// ID may be left by a failed batch
+			event.ID = 0
+			// Postgres keeps microseconds, the hash must match the stored time
+			event.CreatedAt = event.CreatedAt.UTC().Truncate(time.Microsecond)
+			event.PrevHash = prevHash
+			event.Hash = auditHash(event)
+			if err := tx.Create(event).Error; err != nil {
+				return err
+			}
+			prevHash = event.Hash
+		}
+		return nil
+	})
+}
+
+// auditHash computes the hash of an event and its previous hash, over a canonical form
+// which does not change once stored (jsonb reorders keys, timestamps are read in another zone)
+func auditHash(event *models.AuditEvent) string {
+	canonical, _ := json.Marshal(struct {
+		PrevHash   string          `json:"prevHash"`
+		CreatedAt  string          `json:"createdAt"`
+		ActorID    *uint           `json:"actorId"`
+		Action     string          `json:"action"`
+		TargetType string          `json:"targetType"`
+		TargetID   string          `json:"targetId"`
+		Before     json.RawMessage `json:"before"`
+		After      json.RawMessage `json:"after"`
+		IP         string          `json:"ip"`
+		UserAgent  string          `json:"userAgent"`
+		RequestID  string          `json:"requestId"`
+	}{
+		PrevHash:   event.PrevHash,
+		CreatedAt:  event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ActorID:    event.ActorID,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		Before:     canonicalJSON(event.Before),
+		After:      canonicalJSON(event.After),
+		IP:         event.IP,
+		UserAgent:  event.UserAgent,
+		RequestID:  event.RequestID,
+	})
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalJSON re-encodes a JSON value with sorted keys and no spaces
+func canonicalJSON(raw models.JSON) json.RawMessage {
+	var v interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &v) != nil {
+		return json.RawMessage("null")
+	}
+	canonical, _ := json.Marshal(v)
+	return canonical
+}
+
+// checkpointDigest is the digest signed by a checkpoint
+func checkpointDigest(eventID uint, hash string) []byte {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", eventID, hash)))
+	return sum[:]
+}
+
+// CreateAuditCheckpoint signs the hash of the last audit event with the active ECDSA JWT key.
+// Nothing is created if there is no chained event yet or the last one is already signed.
+func CreateAuditCheckpoint() (*models.AuditCheckpoint, error) {
+	privateKey, err := utils.LoadEcdsaPrivateKeyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var last models.AuditEvent
+	if res := adminDB.Where("hash <> ''").Order("id desc").Limit(1).Find(&last); res.Error != nil {
+		return nil, res.Error
+	} else if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, checkpointDigest(last.ID, last.Hash))
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := models.AuditCheckpoint{
+		EventID:   last.ID,
+		Hash:      last.Hash,
+		KeyID:     utils.Thumbprint(privateKey),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}
+	if err := adminDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&checkpoint).Error; err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// CreateAuditCheckpointEvery signs the audit chain at every interval
+func CreateAuditCheckpointEvery(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := CreateAuditCheckpoint(); err != nil {
+				fmt.Printf("failed to create audit checkpoint: %v\n", err)
+			}
+		}
+	}()
+}
+
+// auditTrustedKeys are the retired ECDSA public keys trusted to verify checkpoints besides the JWT key ring, by key ID
+var (
+	auditTrustedKeysMu sync.RWMutex
+	auditTrustedKeys   map[string]*ecdsa.PublicKey
+)
+
+// SetAuditTrustedKeys sets the retired public keys trusted to verify checkpoints
+func SetAuditTrustedKeys(keys map[string]*ecdsa.PublicKey) {
+	auditTrustedKeysMu.Lock()
+	defer auditTrustedKeysMu.Unlock()
+	auditTrustedKeys = keys
+}
+
+func currentAuditTrustedKeys() map[string]*ecdsa.PublicKey {
+	auditTrustedKeysMu.RLock()
+	defer auditTrustedKeysMu.RUnlock()
+	return auditTrustedKeys
+}
+
+// AuditTrustedKeysFromEnv reads the retired keys trusted to verify checkpoints, from the PEM content of
+// AUDIT_TRUSTED_KEYS (literal \n are allowed) and every PEM file of AUDIT_TRUSTED_KEYS_DIR
+func AuditTrustedKeysFromEnv() (map[string]*ecdsa.PublicKey, error) {
+	keys := make(map[string]*ecdsa.PublicKey)
+	if inline := config.GetEnv("AUDIT_TRUSTED_KEYS"); inline != "" {
+		if err := parseAuditKeys(keys, []byte(strings.ReplaceAll(inline, `\n`, "\n"))); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir := config.GetEnv("AUDIT_TRUSTED_KEYS_DIR"); dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			pembytes, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if err := parseAuditKeys(keys, pembytes); err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// parseAuditKeys adds the ECDSA public keys of a PEM bundle to keys, private keys are accepted for their public key
+func parseAuditKeys(keys map[string]*ecdsa.PublicKey, pembytes []byte) error {
+	rest := pembytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil
+		}
+
+		var publicKey *ecdsa.PublicKey
+		if block.Type == "PUBLIC KEY" {
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			if publicKey, ok = parsed.(*ecdsa.PublicKey); !ok {
+				return errors.New("audit key is not an ECDSA key")
+			}
+		} else {
+			privateKeys, err := utils.ParsePrivateKeys(utils.AlgorithmES256, pem.EncodeToMemory(block))
+			if err != nil {
+				return err
+			}
+			publicKey = &privateKeys[0].(*ecdsa.PrivateKey).PublicKey
+		}
+		keys[utils.Thumbprint(publicKey)] = publicKey
+	}
+}
+
+// verifyCheckpoint checks the signature of a checkpoint with the JWT key which signed it, or with a trusted
+// retired key. The public key stored with the checkpoint is never trusted, anyone writing the audit tables
+// could sign with their own. Returns whether the key is a retired one.
+func verifyCheckpoint(ring *utils.KeyRing, trusted map[string]*ecdsa.PublicKey, checkpoint models.AuditCheckpoint) (bool, error) {
+	if ring != nil {
+		if signingKey := ring.Keys[checkpoint.KeyID]; signingKey != nil {
+			privateKey, ok := signingKey.PrivateKey.(*ecdsa.PrivateKey)
+			if !ok {
+				return false, errors.New("checkpoint key is not an ECDSA key: " + checkpoint.KeyID)
+			}
+			return false, verifyCheckpointSignature(&privateKey.PublicKey, checkpoint)
+		}
+	}
+
+	if publicKey := trusted[checkpoint.KeyID]; publicKey != nil {
+		return true, verifyCheckpointSignature(publicKey, checkpoint)
+	}
+	return false, errors.New("checkpoint is signed by an untrusted key: " + checkpoint.KeyID)
+}
+
+func verifyCheckpointSignature(publicKey *ecdsa.PublicKey, checkpoint models.AuditCheckpoint) error {
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil || !ecdsa.VerifyASN1(publicKey, checkpointDigest(checkpoint.EventID, checkpoint.Hash), signature) {
+		return errors.New("checkpoint signature is invalid")
+	}
+	return nil
+}
+
+// VerifyAuditChain walks the audit log from the first event, checking every hash and link
+// and the signature of every checkpoint. It stops at the first broken link.
+func VerifyAuditChain() (models.AuditVerification, error) {
+	var report models.AuditVerification
+
+	var checkpointList []models.AuditCheckpoint
+	if err := adminDB.Order("event_id").Find(&checkpointList).Error; err != nil {
+		return report, err
+	}
+	checkpoints := make(map[uint]models.AuditCheckpoint, len(checkpointList))
+	for _, checkpoint := range checkpointList {
+		checkpoints[checkpoint.EventID] = checkpoint
+	}
+	ring, _ := utils.CurrentKeyRing()
+	trusted := currentAuditTrustedKeys()
+
+	rows, err := adminDB.Model(&models.AuditEvent{}).Order("id").Rows()
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	broken := func(eventID uint, reason string) {
+		report.BrokenEventID = &eventID
+		report.Reason = reason
+	}
+
+	chained := false
+	for rows.Next() && report.BrokenEventID == nil {
+		var event models.AuditEvent
+		if err := adminDB.ScanRows(rows, &event); err != nil {
+			return report, err
+		}
+
+		// Events written before chaining come first and are not chained
+		if event.Hash == "" && !chained {
+			report.Unchained++
+			continue
+		}
+		chained = true
+
+		switch {
+		case event.Hash == "":
+			broken(event.ID, "event is not chained")
+		case event.PrevHash != report.LastHash:
+			broken(event.ID, "previous hash does not match, an event was removed or inserted before this one")
+		case auditHash(&event) != event.Hash:
+			broken(event.ID, "hash does not match, the event was modified")
+		default:
+			report.Checked++
+			report.LastHash = event.Hash
+		}
+
+		if checkpoint, ok := checkpoints[event.ID]; ok && report.BrokenEventID == nil {
+			if checkpoint.Hash != event.Hash {
+				broken(event.ID, "hash does not match the signed checkpoint")
+			} else if retired, err := verifyCheckpoint(ring, trusted, checkpoint); err != nil {
+				broken(event.ID, err.Error())
+			} else if retired && !containsString(report.RetiredKeys, checkpoint.KeyID) {
+				report.RetiredKeys = append(report.RetiredKeys, checkpoint.KeyID)
+			}
+			report.Checkpoints++
+			delete(checkpoints, event.ID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	// Every signed event must still exist
+	if report.BrokenEventID == nil {
+		for _, checkpoint := range checkpointList {
+			if _, missing := checkpoints[checkpoint.EventID]; missing {
+				broken(checkpoint.EventID, "event of a signed checkpoint is missing")
+				break
+			}
+		}
+	}
+
+	report.Valid = report.BrokenEventID == nil
+	return report, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
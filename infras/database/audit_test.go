@@ -0,0 +1,88 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+)
+
+// signCheckpoint signs a checkpoint like CreateAuditCheckpoint
+func signCheckpoint(t *testing.T, privateKey *ecdsa.PrivateKey, eventID uint, hash string) models.AuditCheckpoint {
+	t.Helper()
+
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, checkpointDigest(eventID, hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.AuditCheckpoint{
+		EventID:   eventID,
+		Hash:      hash,
+		KeyID:     utils.Thumbprint(privateKey),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}
+}
+
+func newECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyCheckpointAfterRotation(t *testing.T) {
+	retiredKey := newECDSAKey(t)
+	activeKey := newECDSAKey(t)
+	ring, err := utils.NewKeyRing(utils.AlgorithmES256, []*utils.SigningKey{{PrivateKey: activeKey}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retired key configured by the operator, as the PEM stored with its checkpoints
+	trusted := make(map[string]*ecdsa.PublicKey)
+	if err := parseAuditKeys(trusted, []byte(signCheckpoint(t, retiredKey, 0, "").PublicKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	retired, err := verifyCheckpoint(ring, trusted, signCheckpoint(t, retiredKey, 1, "a"))
+	if err != nil || !retired {
+		t.Fatalf("checkpoint of a trusted rotated key should verify as retired, got %v %v", retired, err)
+	}
+
+	retired, err = verifyCheckpoint(ring, trusted, signCheckpoint(t, activeKey, 2, "b"))
+	if err != nil || retired {
+		t.Fatalf("checkpoint of the active key should verify, got %v %v", retired, err)
+	}
+
+	// Hash signed by another key than the stored one
+	forged := signCheckpoint(t, retiredKey, 3, "c")
+	forged.Hash = "d"
+	if _, err := verifyCheckpoint(ring, trusted, forged); err == nil {
+		t.Fatal("checkpoint of a modified hash should not verify")
+	}
+
+	// Signed with a key embedded in the row only, Eg. by someone rewriting the audit tables
+	unknown := signCheckpoint(t, newECDSAKey(t), 4, "e")
+	if _, err := verifyCheckpoint(ring, trusted, unknown); err == nil {
+		t.Fatal("checkpoint signed by an unknown embedded key should not verify")
+	}
+
+	// Rotated key is not trusted unless configured
+	if _, err := verifyCheckpoint(ring, nil, signCheckpoint(t, retiredKey, 5, "f")); err == nil {
+		t.Fatal("checkpoint of a rotated key which is not configured should not verify")
+	}
+}
@@ -1,13 +1,26 @@
 package database
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
 	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/pcminh0505/gofiber-casbin/config"
 )
 
-func Casbin() *casbin.Enforcer {
+var (
+	enforcer      *casbin.SyncedEnforcer
+	policyWatcher *PolicyWatcher
+)
+
+// Casbin returns the enforcer shared by the whole app, creating it at first call
+func Casbin() *casbin.SyncedEnforcer {
+	if enforcer != nil {
+		return enforcer
+	}
+
 	// Initialize  casbin adapter
 	adapter, err := gormadapter.NewAdapterByDB(adminDB)
 	if err != nil {
@@ -15,11 +28,22 @@ func Casbin() *casbin.Enforcer {
 	}
 
 	// Load model configuration file and policy store adapter
-	e, err := casbin.NewEnforcer("config/restful_rbac_model.conf", adapter)
+	e, err := casbin.NewSyncedEnforcer("config/restful_rbac_model.conf", adapter)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create casbin enforcer: %v", err))
 	}
 
+	// Keep in-memory policy in sync with other instances
+	if watcher := newWatcher(config.GetEnv("CASBIN_WATCHER")); watcher != nil {
+		if err := e.SetWatcher(watcher); err != nil {
+			panic(fmt.Sprintf("failed to set casbin watcher: %v", err))
+		}
+		watcher.SetUpdateCallback(func(payload string) {
+			applyPolicyMessage(e, payload)
+		})
+		policyWatcher = watcher
+	}
+
 	// Add policy - One-time run
 	if hasPolicy := e.HasPolicy("admin", "/api/admin/*", "(GET)|(POST)|(PUT)|(DELETE)"); !hasPolicy {
 		e.AddPolicy("admin", "/api/admin/*", "(GET)|(POST)|(PUT)|(DELETE)")
@@ -29,5 +53,80 @@ func Casbin() *casbin.Enforcer {
 	}
 
 	e.LoadPolicy()
+	if err := LoadRoleExpiries(); err != nil {
+		panic(fmt.Sprintf("failed to load role expiries: %v", err))
+	}
+	enforcer = e
 	return e
 }
+
+// newWatcher creates the policy watcher configured by CASBIN_WATCHER (postgres, local or none)
+func newWatcher(kind string) *PolicyWatcher {
+	switch kind {
+	case "none":
+		return nil
+	case "local":
+		return NewPolicyWatcher(NewLocalBus())
+	case "postgres", "":
+		return NewPolicyWatcher(NewPostgresBus(getDataSourceName(admin)))
+	default:
+		panic(fmt.Sprintf("unknown casbin watcher: %s", kind))
+	}
+}
+
+// applyPolicyMessage applies a policy change made by another instance to the in-memory policy
+func applyPolicyMessage(e *casbin.SyncedEnforcer, payload string) {
+	var msg PolicyMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		return
+	}
+
+	// Role expiries are kept beside the policy
+	if msg.Method == methodExpiry {
+		roleExpiries.apply([]policyChange{{method: methodExpiry, rules: msg.Rules}})
+		return
+	}
+
+	lock := e.GetLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	m := e.GetModel()
+	if _, ok := m[msg.Sec][msg.Ptype]; !ok && msg.Method != methodReload {
+		msg.Method = methodReload
+	}
+
+	var err error
+	switch msg.Method {
+	case methodAdd:
+		added := m.AddPoliciesWithAffected(msg.Sec, msg.Ptype, msg.Rules)
+		if msg.Sec == "g" {
+			err = e.Enforcer.BuildIncrementalRoleLinks(model.PolicyAdd, msg.Ptype, added)
+		}
+	case methodRemove:
+		removed := m.RemovePoliciesWithEffected(msg.Sec, msg.Ptype, msg.Rules)
+		if msg.Sec == "g" {
+			err = e.Enforcer.BuildIncrementalRoleLinks(model.PolicyRemove, msg.Ptype, removed)
+			roleExpiries.apply([]policyChange{{method: methodRemove, sec: msg.Sec, ptype: msg.Ptype, rules: removed}})
+		}
+	case methodRemoveFiltered:
+		_, removed := m.RemoveFilteredPolicy(msg.Sec, msg.Ptype, msg.FieldIndex, msg.FieldValues...)
+		if msg.Sec == "g" {
+			err = e.Enforcer.BuildIncrementalRoleLinks(model.PolicyRemove, msg.Ptype, removed)
+			roleExpiries.apply([]policyChange{{method: methodRemove, sec: msg.Sec, ptype: msg.Ptype, rules: removed}})
+		}
+	default:
+		err = e.Enforcer.LoadPolicy()
+		if err == nil {
+			err = LoadRoleExpiries()
+		}
+	}
+
+	// Fall back to a full reload if the incremental change could not be applied
+	if err != nil && msg.Method != methodReload {
+		err = e.Enforcer.LoadPolicy()
+	}
+	if err != nil {
+		fmt.Printf("failed to sync casbin policy: %v\n", err)
+	}
+}
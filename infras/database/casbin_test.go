@@ -0,0 +1,138 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// openTestDB replaces AdminDB by a fresh SQLite database
+func openTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "admin.db")
+	db, err := gorm.Open(sqlite.Open(path+"?_pragma=busy_timeout(5000)"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminDB = db
+	return db
+}
+
+// newTestEnforcer creates an enforcer on AdminDB, synced through bus if not nil
+func newTestEnforcer(t testing.TB, bus PolicyBus) *casbin.SyncedEnforcer {
+	t.Helper()
+
+	adapter, err := gormadapter.NewAdapterByDB(adminDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := casbin.NewSyncedEnforcer("../../config/restful_rbac_model.conf", adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bus != nil {
+		watcher := NewPolicyWatcher(bus)
+		t.Cleanup(watcher.Close)
+		if err := e.SetWatcher(watcher); err != nil {
+			t.Fatal(err)
+		}
+		watcher.SetUpdateCallback(func(payload string) {
+			applyPolicyMessage(e, payload)
+		})
+	}
+	return e
+}
+
+// seedPolicies adds the default policies and n users of each role
+func seedPolicies(t testing.TB, e *casbin.SyncedEnforcer, n int) {
+	t.Helper()
+
+	rules := [][]string{
+		{"admin", "/api/admin/*", "(GET)|(POST)|(PUT)|(DELETE)"},
+		{"user", "/api/users/:id/*", "(GET)|(PUT)"},
+	}
+	if _, err := e.AddPolicies(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	var groupings [][]string
+	for i := 0; i < n; i++ {
+		groupings = append(groupings, []string{fmt.Sprint(2 * i), "admin"}, []string{fmt.Sprint(2*i + 1), "user"})
+	}
+	if _, err := e.AddGroupingPolicies(groupings); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkEnforceShared enforces on the in-memory policy kept up to date by the watcher
+func BenchmarkEnforceShared(b *testing.B) {
+	openTestDB(b)
+	e := newTestEnforcer(b, nil)
+	seedPolicies(b, e, 500)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ok, err := e.Enforce("42", "/api/admin/users", "GET"); err != nil || !ok {
+			b.Fatal("request should be allowed", err)
+		}
+	}
+}
+
+// BenchmarkEnforceReload enforces after reloading the policy, as every request did before the watcher
+func BenchmarkEnforceReload(b *testing.B) {
+	openTestDB(b)
+	e := newTestEnforcer(b, nil)
+	seedPolicies(b, e, 500)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := e.LoadPolicy(); err != nil {
+			b.Fatal(err)
+		}
+		if ok, err := e.Enforce("42", "/api/admin/users", "GET"); err != nil || !ok {
+			b.Fatal("request should be allowed", err)
+		}
+	}
+}
+
+func TestLocalBusSyncsEnforcers(t *testing.T) {
+	openTestDB(t)
+	bus := NewLocalBus()
+	first := newTestEnforcer(t, bus)
+	second := newTestEnforcer(t, bus)
+	seedPolicies(t, first, 1)
+
+	if _, err := first.AddGroupingPolicy("7", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		ok, _ := second.Enforce("7", "/api/admin/users", "GET")
+		return ok
+	})
+
+	if _, err := first.RemoveGroupingPolicy("7", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !second.HasGroupingPolicy("7", "admin") })
+}
+
+// waitFor fails the test if cond is still false after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,394 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"gorm.io/gorm"
+)
+
+// policyTxLock is the advisory lock serializing policy transactions of every instance
+const policyTxLock = 0x63617362 // "casb"
+
+// policyTxMu serializes policy transactions of this instance, so that the rules a transaction
+// reads from memory are not changed by another one before it is applied
+var policyTxMu sync.Mutex
+
+// PolicyTx changes Casbin rules within a database transaction, see CasbinTransaction
+type PolicyTx struct {
+	e       *casbin.SyncedEnforcer
+	adapter *gormadapter.Adapter
+	changes []policyChange
+	pending map[string]pendingRule // Rules changed by the transaction, by ruleKey
+}
+
+// policyChange is a change of rules written in the transaction, applied in memory once committed
+type policyChange struct {
+	method string // methodAdd, methodRemove or methodExpiry
+	sec    string
+	ptype  string
+	rules  [][]string
+}
+
+// pendingRule is the state of a rule after the changes of the transaction
+type pendingRule struct {
+	sec     string
+	ptype   string
+	rule    []string
+	present bool
+}
+
+// CasbinTransaction runs fn in a database transaction. Rules changed through the PolicyTx are
+// written to casbin_rule by an adapter bound to the same transaction, so that rows of fn and
+// rules are committed together. The in-memory policy is only changed once committed, so requests
+// are never authorized by rules which may be rolled back, then other instances are notified.
+// Policy transactions are serialized, fn must not start another one.
+func CasbinTransaction(e *casbin.SyncedEnforcer, fn func(tx *gorm.DB, policy *PolicyTx) error) error {
+	policyTxMu.Lock()
+	defer policyTxMu.Unlock()
+
+	var policy *PolicyTx
+	err := adminDB.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", policyTxLock).Error; err != nil {
+				return err
+			}
+		}
+
+		// Table is already migrated by the shared adapter
+		db := tx.Session(&gorm.Session{NewDB: true})
+		gormadapter.TurnOffAutoMigrate(db)
+		adapter, err := gormadapter.NewAdapterByDB(db)
+		if err != nil {
+			return err
+		}
+
+		policy = &PolicyTx{e: e, adapter: adapter, pending: make(map[string]pendingRule)}
+		return fn(tx, policy)
+	})
+	if err != nil {
+		return err
+	}
+
+	policy.commit()
+	policy.notify()
+	return nil
+}
+
+// HasPolicy checks if a permission is in the policy as changed by the transaction so far
+func (p *PolicyTx) HasPolicy(params ...string) bool {
+	return p.has("p", "p", params)
+}
+
+// GetFilteredPolicy returns the permissions matching field values in the policy as changed by the
+// transaction so far, every permission without values
+func (p *PolicyTx) GetFilteredPolicy(fieldIndex int, fieldValues ...string) [][]string {
+	return p.filtered("p", "p", fieldIndex, fieldValues...)
+}
+
+// AddPolicy adds a permission (subject, object, action)
+func (p *PolicyTx) AddPolicy(params ...string) error {
+	return p.addPolicies("p", "p", [][]string{params})
+}
+
+// RemovePolicy removes a permission (subject, object, action)
+func (p *PolicyTx) RemovePolicy(params ...string) error {
+	return p.removePolicies("p", "p", [][]string{params})
+}
+
+// AddPolicies adds permissions (subject, object, action)
+func (p *PolicyTx) AddPolicies(rules [][]string) error {
+	return p.addPolicies("p", "p", rules)
+}
+
+// RemovePolicies removes permissions (subject, object, action)
+func (p *PolicyTx) RemovePolicies(rules [][]string) error {
+	return p.removePolicies("p", "p", rules)
+}
+
+// RemoveFilteredPolicy removes the permissions matching field values, Eg. (0, role)
+func (p *PolicyTx) RemoveFilteredPolicy(fieldIndex int, fieldValues ...string) error {
+	return p.removePolicies("p", "p", p.filtered("p", "p", fieldIndex, fieldValues...))
+}
+
+// AddGroupingPolicy assigns a role to a subject (user ID or role)
+func (p *PolicyTx) AddGroupingPolicy(params ...string) error {
+	return p.addPolicies("g", "g", [][]string{params})
+}
+
+// RemoveGroupingPolicy removes a role of a subject
+func (p *PolicyTx) RemoveGroupingPolicy(params ...string) error {
+	return p.removePolicies("g", "g", [][]string{params})
+}
+
+// RemoveFilteredGroupingPolicy removes the role assignments matching field values, Eg. (0, userID)
+func (p *PolicyTx) RemoveFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) error {
+	return p.removePolicies("g", "g", p.filtered("g", "g", fieldIndex, fieldValues...))
+}
+
+// HasGroupingPolicy checks if a role assignment is in the policy as changed by the transaction so far
+func (p *PolicyTx) HasGroupingPolicy(params ...string) bool {
+	return p.has("g", "g", params)
+}
+
+// GetImplicitRoles returns the roles a subject inherits directly or through other roles,
+// in the policy as changed by the transaction so far
+func (p *PolicyTx) GetImplicitRoles(subject string) []string {
+	var roles []string
+	seen := map[string]bool{subject: true}
+	queue := []string{subject}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, rule := range p.filtered("g", "g", 0, name) {
+			if len(rule) < 2 || seen[rule[1]] {
+				continue
+			}
+			seen[rule[1]] = true
+			roles = append(roles, rule[1])
+			queue = append(queue, rule[1])
+		}
+	}
+	return roles
+}
+
+// UpdateGroupingPolicy replaces a role assignment by another one
+func (p *PolicyTx) UpdateGroupingPolicy(oldRule []string, newRule []string) error {
+	if err := p.RemoveGroupingPolicy(oldRule...); err != nil {
+		return err
+	}
+	return p.AddGroupingPolicy(newRule...)
+}
+
+// SetRoles replaces the roles directly assigned to a subject by the given ones,
+// only the differences are written. Returns the roles added and removed.
+func (p *PolicyTx) SetRoles(subject string, roles []string) (added []string, removed []string, err error) {
+	desired := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		desired[role] = true
+	}
+
+	current := make(map[string]bool)
+	for _, rule := range p.filtered("g", "g", 0, subject) {
+		if len(rule) < 2 {
+			continue
+		}
+		current[rule[1]] = true
+		if !desired[rule[1]] {
+			removed = append(removed, rule[1])
+		}
+	}
+	for _, role := range roles {
+		if !current[role] {
+			added = append(added, role)
+			current[role] = true
+		}
+	}
+
+	for _, role := range removed {
+		if err := p.RemoveGroupingPolicy(subject, role); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, role := range added {
+		if err := p.AddGroupingPolicy(subject, role); err != nil {
+			return nil, nil, err
+		}
+	}
+	return added, removed, nil
+}
+
+// addPolicies writes the rules which are not in the policy yet
+func (p *PolicyTx) addPolicies(sec string, ptype string, rules [][]string) error {
+	var missing [][]string
+	for _, rule := range rules {
+		if !p.has(sec, ptype, rule) && !containsRule(missing, rule) {
+			missing = append(missing, rule)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := p.adapter.AddPolicies(sec, ptype, missing); err != nil {
+		return err
+	}
+	p.record(policyChange{method: methodAdd, sec: sec, ptype: ptype, rules: missing})
+	return nil
+}
+
+// removePolicies deletes the rules which are in the policy
+func (p *PolicyTx) removePolicies(sec string, ptype string, rules [][]string) error {
+	var existing [][]string
+	for _, rule := range rules {
+		if p.has(sec, ptype, rule) && !containsRule(existing, rule) {
+			existing = append(existing, rule)
+		}
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	if err := p.adapter.RemovePolicies(sec, ptype, existing); err != nil {
+		return err
+	}
+	p.record(policyChange{method: methodRemove, sec: sec, ptype: ptype, rules: existing})
+	return nil
+}
+
+// has checks if a rule is in the policy as changed by the transaction so far
+func (p *PolicyTx) has(sec string, ptype string, rule []string) bool {
+	if pending, ok := p.pending[ruleKey(sec, ptype, rule)]; ok {
+		return pending.present
+	}
+	if sec == "g" {
+		return p.e.HasNamedGroupingPolicy(ptype, rule)
+	}
+	return p.e.HasNamedPolicy(ptype, rule)
+}
+
+// filtered returns the rules matching field values in the policy as changed by the transaction so far
+func (p *PolicyTx) filtered(sec string, ptype string, fieldIndex int, fieldValues ...string) [][]string {
+	var rules [][]string
+	if sec == "g" {
+		rules = p.e.GetFilteredNamedGroupingPolicy(ptype, fieldIndex, fieldValues...)
+	} else {
+		rules = p.e.GetFilteredNamedPolicy(ptype, fieldIndex, fieldValues...)
+	}
+
+	result := make([][]string, 0, len(rules))
+	for _, rule := range rules {
+		if pending, ok := p.pending[ruleKey(sec, ptype, rule)]; !ok || pending.present {
+			result = append(result, rule)
+		}
+	}
+
+	// Rules added by the transaction, in a stable order
+	keys := make([]string, 0, len(p.pending))
+	for key, pending := range p.pending {
+		if pending.present && pending.sec == sec && pending.ptype == ptype && matchesFilter(pending.rule, fieldIndex, fieldValues) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if rule := p.pending[key].rule; !containsRule(result, rule) {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+// record keeps a change written in the transaction, to apply it in memory once committed
+func (p *PolicyTx) record(change policyChange) {
+	for _, rule := range change.rules {
+		p.pending[ruleKey(change.sec, change.ptype, rule)] = pendingRule{
+			sec:     change.sec,
+			ptype:   change.ptype,
+			rule:    rule,
+			present: change.method == methodAdd,
+		}
+	}
+	p.changes = append(p.changes, change)
+}
+
+// commit applies the committed changes to the in-memory policy at once.
+// The whole policy is reloaded if that fails.
+func (p *PolicyTx) commit() {
+	if len(p.changes) == 0 {
+		return
+	}
+	if err := applyPolicyChanges(p.e, p.changes); err != nil {
+		if err := p.e.LoadPolicy(); err != nil {
+			fmt.Printf("failed to reload casbin policy: %v\n", err)
+		}
+	}
+	roleExpiries.apply(p.changes)
+}
+
+// notify publishes the committed changes to other instances
+func (p *PolicyTx) notify() {
+	if policyWatcher == nil {
+		return
+	}
+
+	for _, change := range p.changes {
+		var err error
+		switch change.method {
+		case methodAdd:
+			err = policyWatcher.UpdateForAddPolicies(change.sec, change.ptype, change.rules...)
+		case methodRemove:
+			err = policyWatcher.UpdateForRemovePolicies(change.sec, change.ptype, change.rules...)
+		case methodExpiry:
+			err = policyWatcher.UpdateRoleExpiries(change.rules...)
+		}
+		if err != nil {
+			fmt.Printf("failed to notify casbin policy change: %v\n", err)
+		}
+	}
+}
+
+// applyPolicyChanges adds or removes rules of the in-memory policy in order, without writing them.
+// Enforce waits until every change is applied.
+func applyPolicyChanges(e *casbin.SyncedEnforcer, changes []policyChange) error {
+	lock := e.GetLock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	m := e.GetModel()
+	for _, change := range changes {
+		if change.method == methodExpiry {
+			continue
+		}
+		if change.method == methodAdd {
+			added := m.AddPoliciesWithAffected(change.sec, change.ptype, change.rules)
+			if change.sec == "g" {
+				if err := e.Enforcer.BuildIncrementalRoleLinks(model.PolicyAdd, change.ptype, added); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		removed := m.RemovePoliciesWithEffected(change.sec, change.ptype, change.rules)
+		if change.sec == "g" {
+			if err := e.Enforcer.BuildIncrementalRoleLinks(model.PolicyRemove, change.ptype, removed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ruleKey identifies a rule of a section and policy type
+func ruleKey(sec string, ptype string, rule []string) string {
+	return sec + "\x00" + ptype + "\x00" + strings.Join(rule, "\x00")
+}
+
+// matchesFilter checks if a rule has the field values from fieldIndex, empty values match any field
+func matchesFilter(rule []string, fieldIndex int, fieldValues []string) bool {
+	for i, value := range fieldValues {
+		if value == "" {
+			continue
+		}
+		if fieldIndex+i >= len(rule) || rule[fieldIndex+i] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func containsRule(rules [][]string, rule []string) bool {
+	key := strings.Join(rule, "\x00")
+	for _, r := range rules {
+		if strings.Join(r, "\x00") == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"gorm.io/gorm"
+)
+
+// newTxTestEnforcer opens a SQLite AdminDB with the users table and an enforcer on it
+func newTxTestEnforcer(t *testing.T) *casbin.SyncedEnforcer {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatal(err)
+	}
+	return newTestEnforcer(t, nil)
+}
+
+// countGroupings counts the g rows of casbin_rule for a user
+func countGroupings(t *testing.T, userID string) int64 {
+	t.Helper()
+
+	var count int64
+	if err := adminDB.Model(&gormadapter.CasbinRule{}).Where("ptype = 'g' AND v0 = ?", userID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestCasbinTransactionRollsBackFailedInsert(t *testing.T) {
+	e := newTxTestEnforcer(t)
+	if err := adminDB.Create(&models.User{Username: "taken"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{Username: "alice"}
+	err := CasbinTransaction(e, func(tx *gorm.DB, policy *PolicyTx) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := policy.AddGroupingPolicy(fmt.Sprint(user.ID), "admin"); err != nil {
+			return err
+		}
+
+		// Not committed yet, requests must not be authorized by the new role
+		if e.HasGroupingPolicy(fmt.Sprint(user.ID), "admin") {
+			t.Error("grouping is applied in memory before commit")
+		}
+
+		// Username is unique
+		return tx.Create(&models.User{Username: "taken"}).Error
+	})
+	if err == nil {
+		t.Fatal("transaction should fail on the duplicate username")
+	}
+
+	var users int64
+	adminDB.Model(&models.User{}).Where("username = ?", "alice").Count(&users)
+	if users != 0 {
+		t.Error("user row is committed")
+	}
+	if countGroupings(t, fmt.Sprint(user.ID)) != 0 {
+		t.Error("casbin_rule row is committed")
+	}
+	if e.HasGroupingPolicy(fmt.Sprint(user.ID), "admin") {
+		t.Error("grouping is left in memory")
+	}
+}
+
+func TestCasbinTransactionRollsBackOnError(t *testing.T) {
+	e := newTxTestEnforcer(t)
+	if _, err := e.AddGroupingPolicy("1", "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	err := CasbinTransaction(e, func(tx *gorm.DB, policy *PolicyTx) error {
+		if _, _, err := policy.SetRoles("1", []string{"admin"}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+
+	if !e.HasGroupingPolicy("1", "user") || e.HasGroupingPolicy("1", "admin") {
+		t.Error("in-memory roles changed by a failed transaction")
+	}
+	if countGroupings(t, "1") != 1 {
+		t.Error("casbin_rule rows changed by a failed transaction")
+	}
+}
+
+func TestCasbinTransactionCommits(t *testing.T) {
+	e := newTxTestEnforcer(t)
+
+	user := models.User{Username: "alice"}
+	var added, removed []string
+	err := CasbinTransaction(e, func(tx *gorm.DB, policy *PolicyTx) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		subject := fmt.Sprint(user.ID)
+		if err := policy.AddGroupingPolicy(subject, "user"); err != nil {
+			return err
+		}
+
+		// Pending rules of the transaction are seen by the following changes
+		var err error
+		added, removed, err = policy.SetRoles(subject, []string{"admin", "oncall"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject := fmt.Sprint(user.ID)
+	if fmt.Sprint(added) != "[admin oncall]" || fmt.Sprint(removed) != "[user]" {
+		t.Errorf("unexpected diff: added %v, removed %v", added, removed)
+	}
+	if !e.HasGroupingPolicy(subject, "admin") || !e.HasGroupingPolicy(subject, "oncall") || e.HasGroupingPolicy(subject, "user") {
+		t.Errorf("unexpected roles in memory: %v", e.GetFilteredGroupingPolicy(0, subject))
+	}
+	if countGroupings(t, subject) != 2 {
+		t.Error("casbin_rule rows do not match the roles")
+	}
+}
+
+func TestCasbinTransactionSerializesWriters(t *testing.T) {
+	e := newTxTestEnforcer(t)
+
+	// Every writer sees the rule is missing unless transactions are serialized
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- CasbinTransaction(e, func(tx *gorm.DB, policy *PolicyTx) error {
+				return policy.AddGroupingPolicy("1", "admin")
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := countGroupings(t, "1"); count != 1 {
+		t.Errorf("expected one casbin_rule row, got %d", count)
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+// LoginLockout returns how long logins are still locked for any of the keys, 0 if none is locked
+func LoginLockout(keys ...string) (time.Duration, error) {
+	var failures []models.LoginFailure
+	if err := adminDB.
+		Where("key IN ? AND locked_until > ?", keys, time.Now()).
+		Find(&failures).Error; err != nil {
+		return 0, err
+	}
+
+	var lockout time.Duration
+	for _, failure := range failures {
+		if remaining := time.Until(*failure.LockedUntil); remaining > lockout {
+			lockout = remaining
+		}
+	}
+	return lockout, nil
+}
+
+// RecordLoginFailure counts a failed login for a key. Once maxAttempts is reached the key is locked,
+// doubling the lockout on every further failure (LOGIN_LOCKOUT_BASE up to LOGIN_LOCKOUT_MAX).
+// Failures are forgotten LOGIN_ATTEMPT_WINDOW after the last failure or the end of the lockout, whichever
+// is later, so that lockouts longer than the window keep doubling.
+func RecordLoginFailure(key string, maxAttempts int) error {
+	now := time.Now()
+	window := config.GetEnvDuration("LOGIN_ATTEMPT_WINDOW", 15*time.Minute)
+
+	// Count atomically, concurrent attempts must not be lost
+	var failures int
+	if err := adminDB.Raw(`
+		INSERT INTO login_failures (created_at, updated_at, key, failures, last_failure_at)
+		VALUES (?, ?, ?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN GREATEST(login_failures.last_failure_at, login_failures.locked_until) < ?
+				THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING failures`,
+		now, now, key, now, now.Add(-window),
+	).Scan(&failures).Error; err != nil {
+		return err
+	}
+
+	if failures < maxAttempts {
+		return nil
+	}
+
+	lockout := config.GetEnvDuration("LOGIN_LOCKOUT_BASE", time.Minute)
+	maxLockout := config.GetEnvDuration("LOGIN_LOCKOUT_MAX", time.Hour)
+	for i := maxAttempts; i < failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		lockout = maxLockout
+	}
+
+	return adminDB.Model(&models.LoginFailure{}).
+		Where("key = ?", key).
+		Update("locked_until", now.Add(lockout)).Error
+}
+
+// ResetLoginFailures clears the failures and lockout of keys, after a successful login or an admin unlock
+func ResetLoginFailures(keys ...string) error {
+	return adminDB.Where("key IN ?", keys).Delete(&models.LoginFailure{}).Error
+}
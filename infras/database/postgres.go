@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pcminh0505/gofiber-casbin/api/models"
 	"github.com/pcminh0505/gofiber-casbin/config"
@@ -27,21 +28,60 @@ func Connect() {
 		panic("Cannot connect to database")
 	}
 
+	// Users existing before email verification are considered verified, known before migrating
+	backfillVerified := adminDB.Migrator().HasTable(&models.User{}) &&
+		!adminDB.Migrator().HasColumn(&models.User{}, "email_verified_at")
+
 	// Database migration
 	adminDB.AutoMigrate(
 		&models.User{},
+		&models.Role{},
+		&models.UserRole{},
+		&models.RefreshToken{},
+		&models.TokenRevocation{},
+		&models.LoginFailure{},
+		&models.RateLimitBucket{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.PasswordHistory{},
+		&models.PasswordResetToken{},
+		&models.AuditEvent{},
+		&models.AuditCheckpoint{},
 	)
 
+	if backfillVerified {
+		if err := migrateEmailVerification(); err != nil {
+			panic(fmt.Sprintf("failed to migrate email verification: %v", err))
+		}
+	}
+
+	if err := migrateUserRoles(); err != nil {
+		panic(fmt.Sprintf("failed to migrate user roles: %v", err))
+	}
+
+	// Auto create default roles at first load
+	for _, name := range []string{"admin", "user", config.GetEnv("ROOT_ADMIN_ROLE")} {
+		if name != "" {
+			adminDB.Where(&models.Role{Name: name}).FirstOrCreate(&models.Role{})
+		}
+	}
+
 	// Auto create admin at first load
 	if result := adminDB.First(&models.User{}).RowsAffected; result == 0 {
 		password, _ := bcrypt.GenerateFromPassword([]byte(config.GetEnv("ROOT_ADMIN_PASSWORD")), bcrypt.DefaultCost)
+		// Root admin is trusted, it must log in even if email verification is required
+		verifiedAt := time.Now()
 		data := models.User{
-			Username: config.GetEnv("ROOT_ADMIN_USERNAME"),
-			Password: string(password),
-			Role:     config.GetEnv("ROOT_ADMIN_ROLE"),
+			Username:        config.GetEnv("ROOT_ADMIN_USERNAME"),
+			Password:        string(password),
+			EmailVerifiedAt: &verifiedAt,
 		}
 		adminDB.Create(&data)
-		Casbin().AddGroupingPolicy(fmt.Sprint(data.ID), data.Role)
+
+		role := config.GetEnv("ROOT_ADMIN_ROLE")
+		adminDB.Create(&models.UserRole{UserID: data.ID, Role: role})
+		Casbin().AddGroupingPolicy(fmt.Sprint(data.ID), role)
 	}
 
 }
@@ -50,6 +90,11 @@ func GetAdminDB() *gorm.DB {
 	return adminDB
 }
 
+// SetAdminDB replaces AdminDB, used by tests to run on another database
+func SetAdminDB(db *gorm.DB) {
+	adminDB = db
+}
+
 func getDataSourceName(db string) string {
 	return fmt.Sprintf(
 		"user=%s password=%s host=%s port=%s dbname=%s sslmode=disable TimeZone=Asia/Bangkok",
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxRateLimitPeriod is the longest period of a limit, buckets untouched for longer are full again and dropped
+const MaxRateLimitPeriod = 24 * time.Hour
+
+// RateLimit is a token bucket holding up to Rate tokens, refilled by Rate tokens every Period
+type RateLimit struct {
+	Rate   int
+	Period time.Duration
+}
+
+// RateLimitResult is the state of a bucket after taking a token
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Time until the bucket is full
+	RetryAfter time.Duration // Time until a token is available, if not allowed
+}
+
+// RateLimitStore keeps the token buckets of clients
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// PerSecond returns the refill rate of the bucket
+func (l RateLimit) PerSecond() float64 {
+	return float64(l.Rate) / l.Period.Seconds()
+}
+
+// take refills a bucket for the time elapsed since last and takes one token if available
+func (l RateLimit) take(tokens float64, last time.Time, now time.Time) (float64, RateLimitResult) {
+	capacity := float64(l.Rate)
+	perSecond := l.PerSecond()
+
+	tokens = math.Min(capacity, tokens+now.Sub(last).Seconds()*perSecond)
+
+	res := RateLimitResult{Limit: l.Rate}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration((capacity - tokens) / perSecond * float64(time.Second))
+	return tokens, res
+}
+
+// NewRateLimitStore creates the store configured by RATE_LIMIT_STORE (memory or postgres)
+func NewRateLimitStore() RateLimitStore {
+	switch kind := config.GetEnv("RATE_LIMIT_STORE"); kind {
+	case "memory", "":
+		return NewMemoryRateLimitStore()
+	case "postgres":
+		return NewPostgresRateLimitStore()
+	default:
+		panic(fmt.Sprintf("unknown rate limit store: %s", kind))
+	}
+}
+
+// MemoryRateLimitStore keeps buckets in memory, limits apply per server instance
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // Bucket is full again at this time if not used
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop buckets refilled since their last use, a new bucket is full too
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Rate), updated: now}
+		s.buckets[key] = b
+	}
+
+	tokens, res := limit.take(b.tokens, b.updated, now)
+	b.tokens = tokens
+	b.updated = now
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// PostgresRateLimitStore keeps buckets in AdminDB, limits are shared by every server instance
+type PostgresRateLimitStore struct {
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresRateLimitStore() *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{lastSweep: time.Now()}
+}
+
+func (s *PostgresRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.sweep()
+
+	var res RateLimitResult
+	err := adminDB.Transaction(func(tx *gorm.DB) error {
+		// Create a full bucket if missing, then lock it so concurrent requests are counted in turn
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RateLimitBucket{
+			Key:       key,
+			Tokens:    float64(limit.Rate),
+			UpdatedAt: now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var bucket models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key = ?", key).
+			First(&bucket).Error; err != nil {
+			return err
+		}
+
+		// Time of the row lock, a bucket updated by another instance may look a bit ahead
+		now = time.Now()
+		if bucket.UpdatedAt.After(now) {
+			now = bucket.UpdatedAt
+		}
+
+		var tokens float64
+		tokens, res = limit.take(bucket.Tokens, bucket.UpdatedAt, now)
+		return tx.Model(&bucket).Updates(map[string]interface{}{
+			"tokens":     tokens,
+			"updated_at": now,
+		}).Error
+	})
+	return res, err
+}
+
+// sweep deletes buckets that are full again, at most once a minute
+func (s *PostgresRateLimitStore) sweep() {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	go adminDB.Where("updated_at < ?", time.Now().Add(-MaxRateLimitPeriod)).Delete(&models.RateLimitBucket{})
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+var revocationCache = newLRUCache(10000, config.GetEnvDuration("REVOCATION_CACHE_TTL", 30*time.Second))
+
+// IsTokenRevoked checks if an access token was revoked by itself or with all sessions of its user
+func IsTokenRevoked(jti string, userID uint, issuedAt time.Time) (bool, error) {
+	if jti != "" {
+		revoked, ok := revocationCache.get("jti:" + jti)
+		if !ok {
+			var count int64
+			if err := adminDB.Model(&models.TokenRevocation{}).
+				Where("jti = ?", jti).
+				Count(&count).Error; err != nil {
+				return false, err
+			}
+			revoked = time.Time{}
+			if count > 0 {
+				revoked = time.Now()
+			}
+			revocationCache.set("jti:"+jti, revoked)
+		}
+		if !revoked.IsZero() {
+			return true, nil
+		}
+	}
+
+	revokedAt, ok := revocationCache.get("user:" + fmt.Sprint(userID))
+	if !ok {
+		var revocation models.TokenRevocation
+		res := adminDB.Where("user_id = ? AND jti = ''", userID).
+			Order("created_at desc").
+			Limit(1).
+			Find(&revocation)
+		if res.Error != nil {
+			return false, res.Error
+		}
+		// iat has a precision of one second, tokens issued in the second of the revocation are revoked too
+		revokedAt = revocation.CreatedAt.Truncate(time.Second)
+		revocationCache.set("user:"+fmt.Sprint(userID), revokedAt)
+	}
+
+	return !revokedAt.IsZero() && !issuedAt.After(revokedAt), nil
+}
+
+// RevokeToken revokes a single access token until it expires
+func RevokeToken(jti string, userID uint, expiresAt time.Time) error {
+	if err := adminDB.Create(&models.TokenRevocation{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}).Error; err != nil {
+		return err
+	}
+
+	revocationCache.set("jti:"+jti, time.Now())
+	return nil
+}
+
+// RevokeUserTokens revokes every access token of a user issued until now
+func RevokeUserTokens(userID uint, maxTokenAge time.Duration) error {
+	revocation := models.TokenRevocation{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(maxTokenAge),
+	}
+	if err := adminDB.Create(&revocation).Error; err != nil {
+		return err
+	}
+
+	revocationCache.set("user:"+fmt.Sprint(userID), revocation.CreatedAt.Truncate(time.Second))
+	return nil
+}
+
+// Revocations are kept a little after expiry, while tokens are still accepted within JWT_LEEWAY
+const revocationPurgeDelay = 5 * time.Minute
+
+// PurgeExpiredRevocations removes the revocations of tokens which have all expired
+func PurgeExpiredRevocations() (int64, error) {
+	res := adminDB.Where("expires_at < ?", time.Now().Add(-revocationPurgeDelay)).Delete(&models.TokenRevocation{})
+	return res.RowsAffected, res.Error
+}
+
+// PurgeExpiredRevocationsEvery purges expired revocations at every interval
+func PurgeExpiredRevocationsEvery(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := PurgeExpiredRevocations(); err != nil {
+				fmt.Printf("failed to purge expired token revocations: %v\n", err)
+			}
+		}
+	}()
+}
+
+// lruCache is a size-bounded cache whose entries also expire, so revocations
+// made by other instances are seen after at most ttl
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   time.Time
+	expires time.Time
+}
+
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (l *lruCache) get(key string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		l.order.Remove(el)
+		delete(l.entries, key)
+		return time.Time{}, false
+	}
+
+	l.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (l *lruCache) set(key string, value time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.entries[key]; ok {
+		el.Value = &lruEntry{key: key, value: value, expires: time.Now().Add(l.ttl)}
+		l.order.MoveToFront(el)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value, expires: time.Now().Add(l.ttl)})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+)
+
+func TestRevokeUserTokensIssuedInSameSecond(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&models.TokenRevocation{}); err != nil {
+		t.Fatal(err)
+	}
+	revocationCache = newLRUCache(100, time.Minute)
+
+	// iat of a token issued right before the revocation, in the same second
+	issuedAt := time.Now().Truncate(time.Second)
+	if err := RevokeUserTokens(1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(source string) {
+		if revoked, err := IsTokenRevoked("", 1, issuedAt); err != nil || !revoked {
+			t.Errorf("%s: token of the revocation second accepted (%v)", source, err)
+		}
+		if revoked, err := IsTokenRevoked("", 1, issuedAt.Add(2*time.Second)); err != nil || revoked {
+			t.Errorf("%s: token issued after the revocation rejected (%v)", source, err)
+		}
+	}
+	check("cache")
+
+	// Same cutoff when read back from the database
+	revocationCache = newLRUCache(100, time.Minute)
+	check("database")
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/config"
+	"gorm.io/gorm"
+)
+
+// migrateEmailVerification marks the users created before email verification as verified at their creation,
+// so that they can still log in when EMAIL_VERIFICATION_REQUIRED is enabled
+func migrateEmailVerification() error {
+	return adminDB.Exec("UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL").Error
+}
+
+// UserRetention returns how long deleted users can be restored before being purged, USER_RETENTION (default 30 days)
+func UserRetention() time.Duration {
+	return config.GetEnvDuration("USER_RETENTION", 30*24*time.Hour)
+}
+
+// PurgeDeletedUsers permanently removes users deleted before a time, with their credentials and tokens
+func PurgeDeletedUsers(before time.Time) (int64, error) {
+	var ids []uint
+	if err := adminDB.Unscoped().
+		Model(&models.User{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	err := adminDB.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.RefreshToken{},
+			&models.TokenRevocation{},
+			&models.TwoFactor{},
+			&models.RecoveryCode{},
+			&models.LoginChallenge{},
+			&models.PasswordHistory{},
+			&models.PasswordResetToken{},
+			&models.UserRole{},
+		} {
+			if err := tx.Where("user_id IN ?", ids).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&models.User{}, ids).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// PurgeDeletedUsersEvery purges users deleted for longer than retention at every interval
+func PurgeDeletedUsersEvery(interval time.Duration, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := PurgeDeletedUsers(time.Now().Add(-retention)); err != nil {
+				fmt.Printf("failed to purge deleted users: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"gorm.io/gorm"
+)
+
+// migrateUserRoles moves the role column users had before user_roles, their groupings already exist
+func migrateUserRoles() error {
+	if !adminDB.Migrator().HasColumn(&models.User{}, "role") {
+		return nil
+	}
+
+	return adminDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO user_roles (created_at, user_id, role)
+			SELECT now(), id, role FROM users WHERE role <> ''
+			ON CONFLICT DO NOTHING`).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&models.User{}, "role")
+	})
+}
+
+// roleExpiryIndex keeps the expiry of role assignments in memory, by user ID then role,
+// so that requests are authorized without reading user_roles
+type roleExpiryIndex struct {
+	mu     sync.RWMutex
+	byUser map[string]map[string]time.Time
+}
+
+var roleExpiries = &roleExpiryIndex{byUser: make(map[string]map[string]time.Time)}
+
+// LoadRoleExpiries reads the expiry of every role assignment having one,
+// called at startup and when other instances ask for a reload
+func LoadRoleExpiries() error {
+	var roles []models.UserRole
+	if err := adminDB.Where("expires_at IS NOT NULL").Find(&roles).Error; err != nil {
+		return err
+	}
+
+	byUser := make(map[string]map[string]time.Time)
+	for _, role := range roles {
+		userID := fmt.Sprint(role.UserID)
+		if byUser[userID] == nil {
+			byUser[userID] = make(map[string]time.Time)
+		}
+		byUser[userID][role.Role] = *role.ExpiresAt
+	}
+
+	roleExpiries.mu.Lock()
+	roleExpiries.byUser = byUser
+	roleExpiries.mu.Unlock()
+	return nil
+}
+
+// ExpiredRoles lists the roles of a user past their expiry, which the sweeper has not removed yet
+func ExpiredRoles(userID string) []string {
+	now := time.Now()
+
+	roleExpiries.mu.RLock()
+	defer roleExpiries.mu.RUnlock()
+
+	var expired []string
+	for role, expiresAt := range roleExpiries.byUser[userID] {
+		if !expiresAt.After(now) {
+			expired = append(expired, role)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// ExpiringRoles lists the roles of a user which have an expiry, past or not
+func ExpiringRoles(userID string) []string {
+	roleExpiries.mu.RLock()
+	defer roleExpiries.mu.RUnlock()
+
+	roles := make([]string, 0, len(roleExpiries.byUser[userID]))
+	for role := range roleExpiries.byUser[userID] {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// ActiveImplicitRoles lists the roles of a user, direct or inherited, leaving out the expired
+// roles the sweeper has not removed yet unless they are inherited through another role
+func ActiveImplicitRoles(e *casbin.SyncedEnforcer, userID string) ([]string, error) {
+	expired := ExpiredRoles(userID)
+	if len(expired) == 0 {
+		return e.GetImplicitRolesForUser(userID)
+	}
+
+	direct, err := e.GetRolesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var roles []string
+	for _, role := range direct {
+		if i := sort.SearchStrings(expired, role); i < len(expired) && expired[i] == role {
+			continue
+		}
+		inherited, err := e.GetImplicitRolesForUser(role)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range append([]string{role}, inherited...) {
+			if !seen[r] {
+				seen[r] = true
+				roles = append(roles, r)
+			}
+		}
+	}
+	return roles, nil
+}
+
+// SetRoleExpiry records the expiry of a role assignment written in the transaction, nil if it never expires.
+// The in-memory index is updated once committed, like the policy.
+func (p *PolicyTx) SetRoleExpiry(userID string, role string, expiresAt *time.Time) {
+	expiry := ""
+	if expiresAt != nil {
+		expiry = expiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	p.changes = append(p.changes, policyChange{method: methodExpiry, rules: [][]string{{userID, role, expiry}}})
+}
+
+// apply updates the index with committed changes in order: expiries are set
+// and the expiry of removed role assignments is forgotten
+func (i *roleExpiryIndex) apply(changes []policyChange) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, change := range changes {
+		switch {
+		case change.method == methodExpiry:
+			for _, rule := range change.rules {
+				if len(rule) < 3 {
+					continue
+				}
+				expiresAt, err := time.Parse(time.RFC3339Nano, rule[2])
+				if rule[2] == "" || err != nil {
+					i.forget(rule[0], rule[1])
+					continue
+				}
+				if i.byUser[rule[0]] == nil {
+					i.byUser[rule[0]] = make(map[string]time.Time)
+				}
+				i.byUser[rule[0]][rule[1]] = expiresAt
+			}
+		case change.method == methodRemove && change.sec == "g":
+			for _, rule := range change.rules {
+				if len(rule) >= 2 {
+					i.forget(rule[0], rule[1])
+				}
+			}
+		}
+	}
+}
+
+func (i *roleExpiryIndex) forget(userID string, role string) {
+	delete(i.byUser[userID], role)
+	if len(i.byUser[userID]) == 0 {
+		delete(i.byUser, userID)
+	}
+}
+
+// SweepExpiredRoles removes every expired role assignment with its grouping
+func SweepExpiredRoles(e *casbin.SyncedEnforcer) (int64, error) {
+	now := time.Now()
+
+	var expired []models.UserRole
+	if err := adminDB.Where("expires_at <= ?", now).Find(&expired).Error; err != nil {
+		return 0, err
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	var removed int64
+	err := CasbinTransaction(e, func(tx *gorm.DB, policy *PolicyTx) error {
+		removed = 0
+		for _, role := range expired {
+			// Assignment may have been extended or already removed meanwhile
+			res := tx.Where("expires_at <= ?", now).Delete(&models.UserRole{}, role.ID)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				continue
+			}
+
+			if err := policy.RemoveGroupingPolicy(fmt.Sprint(role.UserID), role.Role); err != nil {
+				return err
+			}
+			// Grouping is already gone if the user is deleted
+			policy.SetRoleExpiry(fmt.Sprint(role.UserID), role.Role, nil)
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// SweepExpiredRolesEvery sweeps expired role assignments at every interval
+func SweepExpiredRolesEvery(e *casbin.SyncedEnforcer, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := SweepExpiredRoles(e); err != nil {
+				fmt.Printf("failed to sweep expired roles: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"gorm.io/gorm"
+)
+
+// newRoleTestEnforcer opens a SQLite AdminDB with user_roles, an enforcer on it and an empty expiry index
+func newRoleTestEnforcer(t *testing.T) *casbin.SyncedEnforcer {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&models.UserRole{}); err != nil {
+		t.Fatal(err)
+	}
+	roleExpiries.byUser = make(map[string]map[string]time.Time)
+	return newTestEnforcer(t, nil)
+}
+
+// assignRole writes a role assignment with its grouping and expiry
+func assignRole(t *testing.T, e *casbin.SyncedEnforcer, userID uint, role string, expiresAt *time.Time) {
+	t.Helper()
+
+	err := CasbinTransaction(e, func(tx *gorm.DB, policy *PolicyTx) error {
+		if err := tx.Create(&models.UserRole{UserID: userID, Role: role, ExpiresAt: expiresAt}).Error; err != nil {
+			return err
+		}
+		policy.SetRoleExpiry(fmt.Sprint(userID), role, expiresAt)
+		return policy.AddGroupingPolicy(fmt.Sprint(userID), role)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoleExpiriesFollowCommits(t *testing.T) {
+	e := newRoleTestEnforcer(t)
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	assignRole(t, e, 1, "admin", &past)
+	assignRole(t, e, 1, "oncall", &future)
+	assignRole(t, e, 1, "user", nil)
+
+	if expired := ExpiredRoles("1"); fmt.Sprint(expired) != "[admin]" {
+		t.Errorf("expected [admin] expired, got %v", expired)
+	}
+
+	// Rolled back expiries are not applied
+	failure := errors.New("failure")
+	err := CasbinTransaction(e, func(tx *gorm.DB, policy *PolicyTx) error {
+		policy.SetRoleExpiry("1", "user", &past)
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatal(err)
+	}
+	if expired := ExpiredRoles("1"); fmt.Sprint(expired) != "[admin]" {
+		t.Errorf("rolled back expiry applied: %v", expired)
+	}
+
+	// Sweeper removes the assignment and forgets its expiry
+	if removed, err := SweepExpiredRoles(e); err != nil || removed != 1 {
+		t.Fatalf("expected one role swept, got %d (%v)", removed, err)
+	}
+	if expired := ExpiredRoles("1"); len(expired) != 0 {
+		t.Errorf("swept role still expired: %v", expired)
+	}
+	if e.HasGroupingPolicy("1", "admin") {
+		t.Error("swept grouping still in memory")
+	}
+	if _, ok := roleExpiries.byUser["1"]["oncall"]; !ok {
+		t.Error("expiry of an active role forgotten")
+	}
+}
+
+func TestActiveImplicitRolesSkipExpiredRoles(t *testing.T) {
+	e := newRoleTestEnforcer(t)
+	if _, err := e.AddGroupingPolicies([][]string{{"admin", "auditor"}, {"oncall", "user"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	assignRole(t, e, 1, "admin", &past)
+	assignRole(t, e, 1, "oncall", nil)
+	assignRole(t, e, 1, "user", &past)
+
+	// Expired admin and its parent are left out, expired user is still inherited through oncall
+	roles, err := ActiveImplicitRoles(e, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(roles)
+	if fmt.Sprint(roles) != "[oncall user]" {
+		t.Errorf("expected [oncall user], got %v", roles)
+	}
+}
+
+func TestRoleExpiriesLoadAndMessages(t *testing.T) {
+	e := newRoleTestEnforcer(t)
+
+	past := time.Now().Add(-time.Minute)
+	if err := adminDB.Create(&models.UserRole{UserID: 1, Role: "admin", ExpiresAt: &past}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddGroupingPolicy("1", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadRoleExpiries(); err != nil {
+		t.Fatal(err)
+	}
+	if expired := ExpiredRoles("1"); fmt.Sprint(expired) != "[admin]" {
+		t.Errorf("expected [admin] expired after load, got %v", expired)
+	}
+
+	// Changes of other instances
+	payload, _ := json.Marshal(PolicyMessage{Method: methodExpiry, Rules: [][]string{{"2", "user", past.Format(time.RFC3339Nano)}}})
+	applyPolicyMessage(e, string(payload))
+	if expired := ExpiredRoles("2"); fmt.Sprint(expired) != "[user]" {
+		t.Errorf("expected [user] expired from a message, got %v", expired)
+	}
+
+	payload, _ = json.Marshal(PolicyMessage{Method: methodRemove, Sec: "g", Ptype: "g", Rules: [][]string{{"1", "admin"}}})
+	applyPolicyMessage(e, string(payload))
+	if expired := ExpiredRoles("1"); len(expired) != 0 {
+		t.Errorf("expiry of a removed grouping kept: %v", expired)
+	}
+}
@@ -0,0 +1,307 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// Postgres channel used to broadcast policy changes
+	policyChannel = "casbin_policy"
+	// Postgres rejects NOTIFY payloads of 8000 bytes or more
+	maxNotifyPayload = 7999
+)
+
+// Policy change methods sent between instances
+const (
+	methodReload         = "reload"
+	methodAdd            = "add"
+	methodRemove         = "remove"
+	methodRemoveFiltered = "removeFiltered"
+	// Expiry of role assignments, rules are (user, role, expiry in RFC 3339 or empty)
+	methodExpiry = "expiry"
+)
+
+// PolicyMessage describes a policy change made by one server instance
+type PolicyMessage struct {
+	Instance    string     `json:"instance"`
+	Method      string     `json:"method"`
+	Sec         string     `json:"sec,omitempty"`
+	Ptype       string     `json:"ptype,omitempty"`
+	Rules       [][]string `json:"rules,omitempty"`
+	FieldIndex  int        `json:"fieldIndex,omitempty"`
+	FieldValues []string   `json:"fieldValues,omitempty"`
+}
+
+// PolicyBus transports policy messages between watchers
+type PolicyBus interface {
+	Publish(payload string) error
+	Subscribe(handler func(payload string)) (unsubscribe func())
+}
+
+// PolicyWatcher implements Casbin's Watcher, WatcherEx and WatcherUpdatable
+// so every instance applies the changes of the others without reloading the whole policy.
+type PolicyWatcher struct {
+	instance    string
+	bus         PolicyBus
+	unsubscribe func()
+	mu          sync.RWMutex
+	callback    func(string)
+}
+
+// NewPolicyWatcher creates a watcher publishing and receiving changes through a bus
+func NewPolicyWatcher(bus PolicyBus) *PolicyWatcher {
+	w := &PolicyWatcher{
+		instance: newInstanceID(),
+		bus:      bus,
+	}
+	w.unsubscribe = bus.Subscribe(w.receive)
+	return w
+}
+
+// receive forwards messages of other instances to the update callback
+func (w *PolicyWatcher) receive(payload string) {
+	var msg PolicyMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil || msg.Instance == w.instance {
+		return
+	}
+
+	w.mu.RLock()
+	callback := w.callback
+	w.mu.RUnlock()
+
+	if callback != nil {
+		callback(payload)
+	}
+}
+
+func (w *PolicyWatcher) publish(msg PolicyMessage) error {
+	msg.Instance = w.instance
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Too large for a notification, ask other instances to reload instead
+	if len(payload) > maxNotifyPayload {
+		payload, _ = json.Marshal(PolicyMessage{Instance: w.instance, Method: methodReload})
+	}
+	return w.bus.Publish(string(payload))
+}
+
+// SetUpdateCallback sets the function called with the payload of every change made by other instances
+func (w *PolicyWatcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callback = callback
+	return nil
+}
+
+// Update asks other instances to reload the whole policy
+func (w *PolicyWatcher) Update() error {
+	return w.publish(PolicyMessage{Method: methodReload})
+}
+
+// Close stops receiving changes from other instances
+func (w *PolicyWatcher) Close() {
+	w.unsubscribe()
+}
+
+func (w *PolicyWatcher) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	return w.publish(PolicyMessage{Method: methodAdd, Sec: sec, Ptype: ptype, Rules: [][]string{params}})
+}
+
+func (w *PolicyWatcher) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	return w.publish(PolicyMessage{Method: methodRemove, Sec: sec, Ptype: ptype, Rules: [][]string{params}})
+}
+
+func (w *PolicyWatcher) UpdateForRemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return w.publish(PolicyMessage{Method: methodRemoveFiltered, Sec: sec, Ptype: ptype, FieldIndex: fieldIndex, FieldValues: fieldValues})
+}
+
+func (w *PolicyWatcher) UpdateForSavePolicy(model model.Model) error {
+	return w.Update()
+}
+
+func (w *PolicyWatcher) UpdateForAddPolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(PolicyMessage{Method: methodAdd, Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+func (w *PolicyWatcher) UpdateForRemovePolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(PolicyMessage{Method: methodRemove, Sec: sec, Ptype: ptype, Rules: rules})
+}
+
+// UpdateRoleExpiries sends the expiry of role assignments, rules are (user, role, expiry)
+func (w *PolicyWatcher) UpdateRoleExpiries(rules ...[]string) error {
+	return w.publish(PolicyMessage{Method: methodExpiry, Rules: rules})
+}
+
+// UpdateForUpdatePolicy asks other instances to reload since Casbin does not tell
+// whether a `p` or a `g` rule was updated
+func (w *PolicyWatcher) UpdateForUpdatePolicy(oldRule, newRule []string) error {
+	return w.Update()
+}
+
+func (w *PolicyWatcher) UpdateForUpdatePolicies(oldRules, newRules [][]string) error {
+	return w.Update()
+}
+
+// LocalBus delivers policy messages between watchers of the same process (dev and tests)
+type LocalBus struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]*localSubscriber
+}
+
+// localSubscriber queues messages so publishers never wait for a slow handler
+type localSubscriber struct {
+	mu     sync.Mutex
+	queue  []string
+	closed bool
+	signal chan struct{}
+}
+
+// NewLocalBus creates an in-process bus
+func NewLocalBus() *LocalBus {
+	return &LocalBus{subscribers: make(map[int]*localSubscriber)}
+}
+
+func (b *LocalBus) Publish(payload string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subscribers {
+		sub.push(payload)
+	}
+	return nil
+}
+
+// Subscribe delivers messages in order on a dedicated goroutine
+func (b *LocalBus) Subscribe(handler func(payload string)) func() {
+	sub := &localSubscriber{signal: make(chan struct{}, 1)}
+
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = sub
+	b.mu.Unlock()
+
+	go func() {
+		for range sub.signal {
+			for _, payload := range sub.drain() {
+				handler(payload)
+			}
+		}
+	}()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.subscribers, id)
+		b.mu.Unlock()
+		sub.close()
+	}
+}
+
+func (s *localSubscriber) push(payload string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.queue = append(s.queue, payload)
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *localSubscriber) drain() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queue
+	s.queue = nil
+	return queue
+}
+
+func (s *localSubscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.signal)
+	}
+}
+
+// PostgresBus delivers policy messages between instances with LISTEN/NOTIFY
+type PostgresBus struct {
+	dsn string
+}
+
+// NewPostgresBus creates a bus on the AdminDB
+func NewPostgresBus(dsn string) *PostgresBus {
+	return &PostgresBus{dsn: dsn}
+}
+
+func (b *PostgresBus) Publish(payload string) error {
+	return adminDB.Exec("SELECT pg_notify(?, ?)", policyChannel, payload).Error
+}
+
+// Subscribe listens on a dedicated connection, reconnecting on failure.
+// A reload is requested after reconnecting since notifications may have been missed.
+func (b *PostgresBus) Subscribe(handler func(payload string)) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		listened := false
+		for ctx.Err() == nil {
+			err := b.listen(ctx, func() {
+				if listened {
+					payload, _ := json.Marshal(PolicyMessage{Method: methodReload})
+					handler(string(payload))
+				}
+				listened = true
+			}, handler)
+
+			if ctx.Err() == nil {
+				fmt.Printf("casbin policy listener stopped: %v\n", err)
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+
+	return cancel
+}
+
+func (b *PostgresBus) listen(ctx context.Context, onListen func(), handler func(payload string)) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+policyChannel); err != nil {
+		return err
+	}
+	onListen()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handler(notification.Payload)
+	}
+}
+
+func newInstanceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,133 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailerFromEnv creates the mailer configured by MAILER:
+//   - smtp: SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD (STARTTLS when offered by the server)
+//   - file: every email is written to MAIL_DIR (default mails), for local dev and tests (default)
+//   - log: every email is printed to stdout with its tokens redacted, links cannot be opened
+func NewMailerFromEnv() Mailer {
+	from := config.GetEnv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+
+	switch kind := config.GetEnv("MAILER"); kind {
+	case "smtp":
+		port := config.GetEnv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Addr:     config.GetEnv("SMTP_HOST") + ":" + port,
+			Host:     config.GetEnv("SMTP_HOST"),
+			Username: config.GetEnv("SMTP_USERNAME"),
+			Password: config.GetEnv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	case "file", "":
+		dir := config.GetEnv("MAIL_DIR")
+		if dir == "" {
+			dir = "mails"
+		}
+		return &FileMailer{Dir: dir, From: from}
+	case "log":
+		return &LogMailer{From: from}
+	default:
+		panic(fmt.Sprintf("unknown mailer: %s", kind))
+	}
+}
+
+// format builds the RFC 5322 message of an email
+func format(from string, msg Message) []byte {
+	// Header values must not contain line breaks
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	var b strings.Builder
+	b.WriteString("From: " + clean.Replace(from) + "\r\n")
+	b.WriteString("To: " + clean.Replace(msg.To) + "\r\n")
+	b.WriteString("Subject: " + clean.Replace(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// FileMailer writes every email as an .eml file of a directory
+type FileMailer struct {
+	Dir  string
+	From string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.n++
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().Format("20060102T150405.000"), m.n)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0600)
+}
+
+// LogMailer prints every email to stdout. Tokens of links are redacted, anyone reading
+// the logs could otherwise reset passwords.
+type LogMailer struct {
+	From string
+}
+
+// tokenParam matches the token of a link, keeping its first characters to tell emails apart
+var tokenParam = regexp.MustCompile(`(token=[^&\s]{0,4})[^&\s]*`)
+
+func (m *LogMailer) Send(msg Message) error {
+	redacted := tokenParam.ReplaceAll(format(m.From, msg), []byte("${1}[redacted]"))
+	fmt.Printf("---- mail ----\n%s\n--------------\n", redacted)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+)
+
+// keygen generates a JWT signing key: go run . keygen -alg ES256 -out private.pem
+func keygen(args []string) int {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	alg := flags.String("alg", utils.AlgorithmES256, "signing algorithm: ES256, RS256, EdDSA or HS256")
+	out := flags.String("out", "private.pem", "output PEM file, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	pembytes, err := utils.GenerateKeyPEM(*alg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *out == "-" {
+		os.Stdout.Write(pembytes)
+		return 0
+	}
+
+	// Never overwrite a key still used to verify issued tokens
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	if _, err := file.Write(pembytes); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
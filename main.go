@@ -1,9 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	_ "github.com/pcminh0505/gofiber-casbin/api/models" // swagger handler
 	"github.com/pcminh0505/gofiber-casbin/api/routes"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/config"
 	_ "github.com/pcminh0505/gofiber-casbin/docs" // docs is generated by Swag CLI
 	"github.com/pcminh0505/gofiber-casbin/infras/database"
 	"github.com/pcminh0505/gofiber-casbin/middleware"
@@ -18,9 +24,40 @@ import (
 // @license.name Apache 2.0
 // @license.url  http://www.apache.org/licenses/LICENSE-2.0.html
 
+// @securityDefinitions.apikey BearerAuth
+// @in                         header
+// @name                       Authorization
+// @description                Type "Bearer" followed by a space and the access token returned by /auth/login
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		os.Exit(keygen(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit-verify" {
+		os.Exit(auditVerify())
+	}
+
+	// Load JWT signing keys once
+	keyProvider, err := utils.NewKeyProviderFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("failed to load JWT signing keys: %v", err))
+	}
+	utils.SetKeyProvider(keyProvider)
+
+	// Issuer, audience, leeway and role claims of JWT, read once instead of on every request
+	utils.SetJWTSettings(utils.JWTSettingsFromEnv())
+
+	// Retired keys still trusted to verify audit checkpoints
+	auditKeys, err := database.AuditTrustedKeysFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("failed to load audit trusted keys: %v", err))
+	}
+	database.SetAuditTrustedKeys(auditKeys)
+
 	app := fiber.New(fiber.Config{
 		BodyLimit: 1024 * 1024 * 2014, // 1 GB
+		// Errors returned by handlers are answered with their status and code
+		ErrorHandler: middleware.NewErrorHandler(middleware.ErrorSettingsFromEnv()),
 	})
 
 	middleware.FiberMiddleware(app)
@@ -28,6 +65,26 @@ func main() {
 
 	database.Connect()
 
+	// Permanently remove users deleted for longer than USER_RETENTION
+	if interval := config.GetEnvDuration("USER_PURGE_INTERVAL", 24*time.Hour); interval > 0 {
+		database.PurgeDeletedUsersEvery(interval, database.UserRetention())
+	}
+
+	// Forget revocations once the tokens they revoke have expired
+	if interval := config.GetEnvDuration("REVOCATION_PURGE_INTERVAL", time.Hour); interval > 0 {
+		database.PurgeExpiredRevocationsEvery(interval)
+	}
+
+	// Sign the audit chain every AUDIT_CHECKPOINT_INTERVAL, with the ECDSA JWT key
+	if interval := config.GetEnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour); interval > 0 {
+		database.CreateAuditCheckpointEvery(interval)
+	}
+
+	// Remove role assignments past their expiry every ROLE_SWEEP_INTERVAL
+	if interval := config.GetEnvDuration("ROLE_SWEEP_INTERVAL", time.Minute); interval > 0 {
+		database.SweepExpiredRolesEvery(database.Casbin(), interval)
+	}
+
 	routes.Setup(app)
 	routes.Swagger(app)
 	routes.NotFoundRoute(app)
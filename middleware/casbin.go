@@ -2,49 +2,79 @@ package middleware
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/casbin/casbin/v2"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/audit"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
 )
 
-func AuthorizeCasbin(e *casbin.Enforcer) fiber.Handler {
+// AuthorizeCasbin returns a middleware which checks the current user against the in-memory policy.
+// Policy is kept up to date by the enforcer's watcher instead of being reloaded on every request.
+func AuthorizeCasbin(e *casbin.SyncedEnforcer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get current user/subject
 		userID, ok := c.Locals("userID").(string)
 
 		if userID == "" || !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error":   true,
-				"message": "Current logged in user not found!",
-			})
-		}
-
-		// Load policy from Database
-		err := e.LoadPolicy()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   true,
-				"message": "Failed to load Casbin policy!",
-			})
+			return utils.NewError(fiber.StatusUnauthorized, utils.CodeUnauthenticated, "Current logged in user not found!")
 		}
 
 		// Casbin enforces policy
-		accepted, err := e.Enforce(fmt.Sprint(userID), c.OriginalURL(), c.Method()) // id - url - method || 1 - /api/admin/users - GET
+		var accepted bool
+		var err error
+		if expired := database.ExpiredRoles(userID); len(expired) > 0 {
+			// Expired roles must not grant access, even before the sweeper removes them
+			accepted, err = enforceWithout(e, userID, expired, c.OriginalURL(), c.Method())
+		} else {
+			accepted, err = e.Enforce(fmt.Sprint(userID), c.OriginalURL(), c.Method()) // id - url - method || 1 - /api/admin/users - GET
+		}
 
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error":   true,
-				"message": "Error when authorizing user's accessibility",
-			})
+			return utils.InternalError("Error when authorizing user's accessibility", err)
 		}
 
 		if !accepted {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"error":   true,
-				"message": "Unauthorized!",
-			})
+			audit.Record(c, audit.Event{Action: audit.ActionAuthzDenied, TargetType: "route", TargetID: c.Method() + " " + c.OriginalURL()})
+			return utils.NewError(fiber.StatusForbidden, utils.CodeForbidden, "Unauthorized!")
 		}
 		return c.Next()
 	}
 }
+
+// enforceWithout enforces a request of a user as if the given roles were not assigned,
+// checking the permissions of the user itself then those of each other role
+func enforceWithout(e *casbin.SyncedEnforcer, userID string, excluded []string, obj string, act string) (bool, error) {
+	// Matcher of the model without roles, the subject of the permission has to be the user.
+	// Deny if the model does not match g(r.sub, p.sub) as expected.
+	matcher := e.GetModel()["m"]["m"].Value
+	if !strings.Contains(matcher, "g(r_sub, p_sub)") {
+		return false, nil
+	}
+	accepted, err := e.EnforceWithMatcher(strings.Replace(matcher, "g(r_sub, p_sub)", "r_sub == p_sub", 1), userID, obj, act)
+	if accepted || err != nil {
+		return accepted, err
+	}
+
+	skip := make(map[string]bool, len(excluded))
+	for _, role := range excluded {
+		skip[role] = true
+	}
+
+	roles, err := e.GetRolesForUser(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if skip[role] {
+			continue
+		}
+		if accepted, err := e.Enforce(role, obj, act); accepted || err != nil {
+			return accepted, err
+		}
+	}
+	return false, nil
+}
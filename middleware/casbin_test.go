@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+	"gorm.io/gorm"
+)
+
+func TestAuthorizeCasbinIgnoresExpiredRoles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.db")
+	db, err := gorm.Open(sqlite.Open(path+"?_pragma=busy_timeout(5000)"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.UserRole{}, &models.AuditEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	database.SetAdminDB(db)
+
+	adapter, err := gormadapter.NewAdapterByDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := casbin.NewSyncedEnforcer("../config/restful_rbac_model.conf", adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.AddPolicies([][]string{
+		{"admin", "/api/admin/*", "(GET)|(POST)|(PUT)|(DELETE)"},
+		{"user", "/api/users/:id/*", "(GET)|(PUT)"},
+		{"1", "/api/reports/*", "GET"},
+	})
+	e.AddGroupingPolicies([][]string{{"1", "admin"}, {"1", "user"}})
+
+	// Admin role expired, the sweeper has not removed it yet
+	past := time.Now().Add(-time.Minute)
+	if err := db.Create(&models.UserRole{UserID: 1, Role: "admin", ExpiresAt: &past}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := database.LoadRoleExpiries(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: NewErrorHandler(ErrorSettings{})})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("userID", "1")
+		return c.Next()
+	})
+	app.Use(AuthorizeCasbin(e))
+	app.Get("/*", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for path, status := range map[string]int{
+		"/api/admin/users":     fiber.StatusForbidden,
+		"/api/users/1/profile": fiber.StatusOK,
+		"/api/reports/daily":   fiber.StatusOK,
+	} {
+		res, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != status {
+			t.Errorf("GET %s: expected %d, got %d", path, status, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/models"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/config"
+)
+
+// MIMEApplicationProblemJSON is the media type of RFC 7807 problem details
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// ErrorSettings tell how errors are answered, read once at startup
+type ErrorSettings struct {
+	AlwaysProblem bool   // Answer problem details even if the client does not ask for them
+	TypeBaseURL   string // Problem types are TypeBaseURL/<code>, about:blank if empty
+}
+
+// ErrorSettingsFromEnv reads ERROR_FORMAT (problem to always answer problem details) and ERROR_TYPE_BASE_URL
+func ErrorSettingsFromEnv() ErrorSettings {
+	return ErrorSettings{
+		AlwaysProblem: config.GetEnv("ERROR_FORMAT") == "problem",
+		TypeBaseURL:   config.GetEnv("ERROR_TYPE_BASE_URL"),
+	}
+}
+
+// NewErrorHandler returns the handler answering the errors returned by handlers with their status and code.
+// Errors are answered as problem details if the client accepts application/problem+json
+// or AlwaysProblem is set, as {"error": true, "code", "message", "fields"} otherwise.
+func NewErrorHandler(settings ErrorSettings) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		return handleError(c, err, settings)
+	}
+}
+
+func handleError(c *fiber.Ctx, err error, settings ErrorSettings) error {
+	var appErr *utils.AppError
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &appErr):
+	case errors.As(err, &fiberErr):
+		// Errors of Fiber itself, Eg. unknown route or body too large
+		appErr = utils.NewError(fiberErr.Code, statusCode(fiberErr.Code), fiberErr.Message)
+	default:
+		appErr = utils.InternalError("Internal Server Error", err)
+	}
+
+	// Causes are logged, never answered
+	if appErr.Status >= fiber.StatusInternalServerError && appErr.Err != nil {
+		fmt.Printf("%s %s: %v\n", c.Method(), c.Path(), appErr)
+	}
+
+	c.Status(appErr.Status)
+	if settings.AlwaysProblem || strings.Contains(c.Get(fiber.HeaderAccept), MIMEApplicationProblemJSON) {
+		problemType := "about:blank"
+		if base := settings.TypeBaseURL; base != "" {
+			problemType = strings.TrimSuffix(base, "/") + "/" + appErr.Code
+		}
+
+		c.Set(fiber.HeaderContentType, MIMEApplicationProblemJSON)
+		body, err := c.App().Config().JSONEncoder(models.Problem{
+			Type:     problemType,
+			Title:    http.StatusText(appErr.Status),
+			Status:   appErr.Status,
+			Detail:   appErr.Message,
+			Instance: c.OriginalURL(),
+			Code:     appErr.Code,
+			Fields:   appErr.Fields,
+		})
+		if err != nil {
+			return err
+		}
+		return c.Send(body)
+	}
+
+	return c.JSON(models.Response{
+		Error:   true,
+		Code:    appErr.Code,
+		Message: appErr.Message,
+		Fields:  appErr.Fields,
+	})
+}
+
+// statusCode derives an error code from an HTTP status, Eg. 413 is request_entity_too_large
+func statusCode(status int) string {
+	switch status {
+	case fiber.StatusNotFound:
+		return utils.CodeNotFound
+	case fiber.StatusTooManyRequests:
+		return utils.CodeRateLimited
+	}
+	if status >= fiber.StatusInternalServerError {
+		return utils.CodeInternal
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
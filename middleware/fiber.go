@@ -5,6 +5,7 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
 // FiberMiddleware provide Fiber's built-in middlewares.
@@ -15,6 +16,8 @@ func FiberMiddleware(a *fiber.App) {
 		cors.New(cors.Config{
 			AllowCredentials: true,
 		}),
+		// Add X-Request-ID to each request, recorded in the audit log.
+		requestid.New(),
 		// Add simple logger.
 		logger.New(),
 		// Add recover from panic
@@ -1,67 +1,57 @@
 package middleware
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
 )
 
 // AuthorizeJWT returns a middleware which secures all the private routes
 func AuthorizeJWT() func(*fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		// Parse jwt token from cookie
-		cookie := c.Cookies("jwt")
+		// Parse jwt token from Authorization header, cookie or query param
+		cookie := utils.ExtractToken(c)
 
-		publicKey := utils.LoadEcdsaPrivateKeyKey().PublicKey
-		// Verify with public key
-		token, err := jwt.ParseWithClaims(cookie, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return &publicKey, nil
-		})
+		// Verify with key selected by kid, tokens of another algorithm than JWT_ALGORITHM are rejected.
+		// Claims are validated with JWT_LEEWAY of clock skew: exp, nbf, iat, iss and aud
+		token, err := jwt.ParseWithClaims(cookie, &utils.Claims{}, utils.KeyFunc)
+
+		if ve, ok := err.(*jwt.ValidationError); ok {
+			if ve.Errors&jwt.ValidationErrorExpired != 0 {
+				return utils.NewError(fiber.StatusUnauthorized, utils.CodeTokenExpired, "JWT Expired!")
+			} else if ve.Errors&(jwt.ValidationErrorNotValidYet|jwt.ValidationErrorIssuedAt) != 0 {
+				// Token is not active yet
+				return utils.NewError(fiber.StatusUnauthorized, utils.CodeTokenNotActive, "Unauthorized token!")
+			} else if ve.Errors&(jwt.ValidationErrorIssuer|jwt.ValidationErrorAudience) != 0 {
+				// Token is issued by or for another service
+				return utils.NewError(fiber.StatusUnauthorized, utils.CodeTokenRejected, "JWT issuer or audience is not accepted!")
+			}
+		}
 
 		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error":   true,
-				"message": "Error when parsing JWT!",
-			})
+			return utils.NewError(fiber.StatusUnauthorized, utils.CodeTokenInvalid, "Error when parsing JWT!")
 		}
 		// Get userID inside cookie subject
-		claims := token.Claims.(*jwt.RegisteredClaims)
+		claims := token.Claims.(*utils.Claims)
 
-		if token.Valid {
-			if claims.ExpiresAt.Unix() < time.Now().Unix() {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"error":   true,
-					"message": "JWT Expired!",
-				})
-			}
-		} else if ve, ok := err.(*jwt.ValidationError); ok {
-			if ve.Errors&jwt.ValidationErrorMalformed != 0 {
-				// Malformed token -> Delete Cookie
-				c.ClearCookie("jwt")
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error":   true,
-					"message": "Missing or malformed JWT!",
-				})
-			} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
-				// Token is either expired or not active yet
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"error":   true,
-					"message": "Unauthorized token!",
-				})
-			} else {
-				// Cannot handle -> Delete Cookie
-				c.ClearCookie("jwt")
-				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-					"error":   true,
-					"message": "Error when processing identity!",
-				})
-			}
+		// Reject token revoked by logout or by revoking all sessions of the user
+		userID, _ := strconv.ParseUint(claims.Subject, 10, 32)
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		if revoked, err := database.IsTokenRevoked(claims.ID, uint(userID), issuedAt); err != nil || revoked {
+			c.ClearCookie("jwt")
+			return utils.NewError(fiber.StatusUnauthorized, utils.CodeTokenRevoked, "JWT has been revoked!")
 		}
 
-		// Store current userID into Fiber Context Locals
+		// Store current userID and embedded roles (if any) into Fiber Context Locals
 		c.Locals("userID", claims.Subject)
+		c.Locals("roles", claims.Roles)
 		return c.Next()
 	}
 }
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pcminh0505/gofiber-casbin/api/utils"
+	"github.com/pcminh0505/gofiber-casbin/config"
+	"github.com/pcminh0505/gofiber-casbin/infras/database"
+)
+
+// defaultRateLimits is used when RATE_LIMITS is not set
+const defaultRateLimits = "auth:*=20/1m,*:admin=600/1m,*:*=120/1m"
+
+// RateLimits maps a route group then a role to a limit, "*" matches any group or role
+type RateLimits map[string]map[string]database.RateLimit
+
+// ParseRateLimits parses a comma separated list of group:role=rate/period (Eg. admin:admin=600/1m)
+func ParseRateLimits(spec string) (RateLimits, error) {
+	limits := make(RateLimits)
+	for _, rule := range strings.Split(spec, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+
+		target, value, ok := strings.Cut(rule, "=")
+		group, role, ok2 := strings.Cut(target, ":")
+		rate, period, ok3 := strings.Cut(value, "/")
+		if !ok || !ok2 || !ok3 || group == "" || role == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, expected group:role=rate/period", rule)
+		}
+
+		n, err := strconv.Atoi(rate)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid rate of %q", rule)
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 || d > database.MaxRateLimitPeriod {
+			return nil, fmt.Errorf("invalid period of %q", rule)
+		}
+
+		if limits[group] == nil {
+			limits[group] = make(map[string]database.RateLimit)
+		}
+		limits[group][role] = database.RateLimit{Rate: n, Period: d}
+	}
+	return limits, nil
+}
+
+// Find returns the limit of a group for a client with the given roles. Rules of the group win over "*" rules,
+// rules of a role over the "*" role, and the most generous limit is used if several roles match.
+func (l RateLimits) Find(group string, roles []string) (database.RateLimit, bool) {
+	for _, g := range []string{group, "*"} {
+		var best database.RateLimit
+		for _, role := range roles {
+			limit, ok := l[g][role]
+			if ok && (best.Rate == 0 || limit.PerSecond() > best.PerSecond()) {
+				best = limit
+			}
+		}
+		if best.Rate > 0 {
+			return best, true
+		}
+	}
+
+	for _, g := range []string{group, "*"} {
+		if limit, ok := l[g]["*"]; ok {
+			return limit, true
+		}
+	}
+	return database.RateLimit{}, false
+}
+
+// RateLimit returns a middleware limiting requests of a route group with token buckets, configured by RATE_LIMITS.
+// Authenticated users are limited by their user ID and their Casbin roles (AuthorizeJWT must run first),
+// other clients by IP.
+func RateLimit(group string, e *casbin.SyncedEnforcer, store database.RateLimitStore) fiber.Handler {
+	spec := config.GetEnv("RATE_LIMITS")
+	if spec == "" {
+		spec = defaultRateLimits
+	}
+	limits, err := ParseRateLimits(spec)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse rate limits: %v", err))
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := "ip:" + c.IP()
+		var roles []string
+		if userID, ok := c.Locals("userID").(string); ok && userID != "" {
+			key = "user:" + userID
+			roles, _ = database.ActiveImplicitRoles(e, userID)
+		}
+
+		limit, ok := limits.Find(group, roles)
+		if !ok {
+			return c.Next()
+		}
+
+		res, err := store.Take(group+":"+key, limit)
+		if err != nil {
+			// Do not lock every client out when the store is down
+			return c.Next()
+		}
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Set("X-RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+
+		if !res.Allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds(res.RetryAfter)))
+			return utils.NewError(fiber.StatusTooManyRequests, utils.CodeRateLimited, "Too many requests, please try again later!")
+		}
+
+		return c.Next()
+	}
+}
+
+// seconds rounds a duration up to whole seconds for HTTP headers
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}